@@ -0,0 +1,188 @@
+package kubetransport
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-tk/kubetransport/internal/k8sclient"
+)
+
+// WithEndpointSlices enables getting and watching the EndpointSlices of the services instead
+// of the Endpoints. With the conditions of the EndpointSlices, the endpoints (pods) which are
+// terminating are not picked for new requests, unless no other endpoint is available, as
+// kube-proxy does. This requires the permission to list and watch endpointslices.
+func WithEndpointSlices() Option {
+	return func(options *options) { options.EndpointSlices = true }
+}
+
+func (ipas *ipAddressesSource) getValuesAndSetWatchWithEndpointSlices() {
+	for {
+		endpointSliceList, err := ipas.k8sClient.ListEndpointSlices(ipas.backgroundCtx, ipas.namespace, ipas.endpointsName)
+		if err != nil {
+			ipas.reportError(err)
+			ipas.valueCallback(ipas, nil, fmt.Errorf("list endpoint slices; namespace=%q endpointsName=%q: %w", ipas.namespace, ipas.endpointsName, err))
+			return
+		}
+		endpointSlices := make(map[string]*k8sclient.EndpointSlice, len(endpointSliceList.Items))
+		for i := range endpointSliceList.Items {
+			endpointSlice := &endpointSliceList.Items[i]
+			endpointSlices[endpointSlice.Metadata.Name] = endpointSlice
+		}
+		ipas.resourceVersion = endpointSliceList.Metadata.ResourceVersion
+		value := ipas.setEndpointSlices(endpointSlices)
+		ipas.options.Logger.Debug("got endpoint slices", ipas.logArgs("found", value != nil, "sliceCount", len(endpointSlices), "addressCount", len(value))...)
+		ipas.eventHistory.RecordIPAddresses(historyEventGet, ipas.resourceVersion, value)
+		ipas.valueCallback(ipas, value, nil)
+		callback := func(eventType k8sclient.EventType, endpointSlice *k8sclient.EndpointSlice) bool {
+			if endpointSlice == nil {
+				return true
+			}
+			if eventType == k8sclient.EventDeleted {
+				delete(endpointSlices, endpointSlice.Metadata.Name)
+			} else {
+				endpointSlices[endpointSlice.Metadata.Name] = endpointSlice
+				ipas.observePropagationDelay(&k8sclient.Endpoints{Metadata: endpointSlice.Metadata})
+			}
+			ipas.resourceVersion = endpointSlice.Metadata.ResourceVersion
+			value := ipas.setEndpointSlices(endpointSlices)
+			ipas.options.Logger.Debug("received endpoint slice event", ipas.logArgs("eventType", eventType, "sliceName", endpointSlice.Metadata.Name, "addressCount", len(value))...)
+			ipas.eventHistory.RecordIPAddresses(string(eventType), ipas.resourceVersion, value)
+			ipas.valueCallback(ipas, value, nil)
+			return true
+		}
+		t0 := time.Now()
+		err = ipas.watch(func() error {
+			return ipas.k8sClient.WatchEndpointSlices(ipas.backgroundCtx, ipas.namespace, ipas.endpointsName, ipas.resourceVersion, callback)
+		})
+		if errors.Is(err, k8sclient.ErrResourceVersionExpired) && time.Since(t0) >= minWatchDuration && !ipas.IsStopped() {
+			// Unlike the endpoints, the endpoint slices have to be listed again, as rewatching
+			// from scratch would miss the ones deleted meanwhile.
+			ipas.options.Logger.Debug("resource version expired, relisting endpoint slices", ipas.logArgs("error", err)...)
+			continue
+		}
+		ipas.reportError(err)
+		ipas.valueCallback(ipas, nil, fmt.Errorf("watch endpoint slices; namespace=%q endpointsName=%q: %w", ipas.namespace, ipas.endpointsName, err))
+		return
+	}
+}
+
+// setEndpointSlices extracts the information of the given endpoint slices by name, and
+// returns the ip addresses, or nil if there is no endpoint slice.
+func (ipas *ipAddressesSource) setEndpointSlices(endpointSlices map[string]*k8sclient.EndpointSlice) []string {
+	if len(endpointSlices) == 0 {
+		ipas.terminatingValue = nil
+		ipas.endpointTopologies = nil
+		return ipas.setEndpoints(nil)
+	}
+	endpoints, terminatingValue, endpointTopologies := convertEndpointSlices(endpointSlices)
+	ipas.terminatingValue = terminatingValue
+	ipas.endpointTopologies = endpointTopologies
+	return ipas.setEndpoints(endpoints)
+}
+
+// convertEndpointSlices converts the given endpoint slices by name into the equivalent
+// endpoints, where the endpoints which are ready go into addresses and the ones which are
+// neither ready nor terminating go into notReadyAddresses, and returns the ip addresses of
+// the endpoints which are terminating but still serving, as well as the topologies of the
+// endpoints by ip address, along with. Only the endpoint slices of one address type are
+// converted, see pickAddressType.
+func convertEndpointSlices(endpointSlices map[string]*k8sclient.EndpointSlice) (*k8sclient.Endpoints, []string, map[string]endpointTopology) {
+	addressType := pickAddressType(endpointSlices)
+	endpointSliceNames := make([]string, 0, len(endpointSlices))
+	for endpointSliceName, endpointSlice := range endpointSlices {
+		if getAddressType(endpointSlice) != addressType {
+			continue
+		}
+		endpointSliceNames = append(endpointSliceNames, endpointSliceName)
+	}
+	sort.Strings(endpointSliceNames)
+	var endpointSubset k8sclient.EndpointSubset
+	var terminatingIPAddresses []string
+	endpointTopologies := make(map[string]endpointTopology)
+	for _, endpointSliceName := range endpointSliceNames {
+		endpointSlice := endpointSlices[endpointSliceName]
+		for i := range endpointSlice.Endpoints {
+			endpoint := &endpointSlice.Endpoints[i]
+			conditions := &endpoint.Conditions
+			// Unknown conditions are interpreted as ready and serving, but not terminating.
+			ready := conditions.Ready == nil || *conditions.Ready
+			serving := conditions.Serving == nil || *conditions.Serving
+			terminating := conditions.Terminating != nil && *conditions.Terminating
+			endpointTopology := endpointTopology{Zone: endpoint.Zone}
+			if hints := endpoint.Hints; hints != nil {
+				for _, forZone := range hints.ForZones {
+					endpointTopology.HintZones = append(endpointTopology.HintZones, forZone.Name)
+				}
+			}
+			for _, ipAddress := range endpoint.Addresses {
+				endpointTopologies[ipAddress] = endpointTopology
+				switch {
+				case ready && !terminating:
+					endpointSubset.Addresses = append(endpointSubset.Addresses, k8sclient.EndpointAddress{
+						IP:        ipAddress,
+						Hostname:  endpoint.Hostname,
+						NodeName:  endpoint.NodeName,
+						TargetRef: endpoint.TargetRef,
+					})
+				case terminating:
+					if serving {
+						terminatingIPAddresses = append(terminatingIPAddresses, ipAddress)
+					}
+				default:
+					endpointSubset.NotReadyAddresses = append(endpointSubset.NotReadyAddresses, k8sclient.EndpointAddress{
+						IP:        ipAddress,
+						Hostname:  endpoint.Hostname,
+						NodeName:  endpoint.NodeName,
+						TargetRef: endpoint.TargetRef,
+					})
+				}
+			}
+		}
+	}
+	endpoints := k8sclient.Endpoints{Subsets: []k8sclient.EndpointSubset{endpointSubset}}
+	return &endpoints, terminatingIPAddresses, endpointTopologies
+}
+
+// pickAddressType returns the address type of the given endpoint slices to use, or an empty
+// one if none is usable. The ones of FQDN hold no ip addresses, and a dual-stack service has
+// the ones of both IPv4 and IPv6 for the same endpoints, of which IPv4 is preferred.
+func pickAddressType(endpointSlices map[string]*k8sclient.EndpointSlice) k8sclient.AddressType {
+	var addressType k8sclient.AddressType
+	for _, endpointSlice := range endpointSlices {
+		switch getAddressType(endpointSlice) {
+		case k8sclient.AddressTypeIPv4:
+			return k8sclient.AddressTypeIPv4
+		case k8sclient.AddressTypeIPv6:
+			addressType = k8sclient.AddressTypeIPv6
+		}
+	}
+	return addressType
+}
+
+// getAddressType returns the address type of the given endpoint slice, which is taken as
+// IPv4 if missing.
+func getAddressType(endpointSlice *k8sclient.EndpointSlice) k8sclient.AddressType {
+	if endpointSlice.AddressType == "" {
+		return k8sclient.AddressTypeIPv4
+	}
+	return endpointSlice.AddressType
+}
+
+// GetTerminatingIPAddresses returns the ip addresses of the endpoints (pods) which are
+// terminating but still serving in the cached endpoints.
+func (er *endpointsRegistry) GetTerminatingIPAddresses(namespace string, endpointsName string) []string {
+	if namespace == "" {
+		namespace = er.k8sClient.Namespace()
+	}
+	value, ok := er.ipAddressesCache.Load(endpointKey{namespace, endpointsName})
+	if !ok {
+		return nil
+	}
+	cachedIPAddresses, ok := value.(*cachedIPAddresses)
+	if !ok {
+		return nil
+	}
+	return cachedIPAddresses.TerminatingValue
+}
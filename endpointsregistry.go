@@ -13,19 +13,44 @@ type endpointsRegistry struct {
 	backgroundCtx    context.Context
 	stop             context.CancelFunc
 	k8sClient        k8sclient.K8sClient
+	options          *options
+	tickInterval     time.Duration
 	ipAddressesCache sync.Map
+	watchFailures    sync.Map
+	eventHistories   sync.Map
+	lastSyncTimes    sync.Map
+	nodeZones        sync.Map
+	trafficSplits    sync.Map
+	failoverGroups   sync.Map
+
+	apiServerLock    sync.Mutex
+	unreachableSince time.Time
+
+	lock             sync.Mutex
+	removalCallbacks []*ipAddressesRemovalCallback
+	subscriberSets   subscriberSets
 }
 
-func newEndpointsRegistry(backgroundCtx context.Context, k8sClient k8sclient.K8sClient, tickInterval time.Duration) *endpointsRegistry {
+type ipAddressesRemovalCallback func(endpointKey endpointKey, ipAddresses []string)
+
+func newEndpointsRegistry(backgroundCtx context.Context, k8sClient k8sclient.K8sClient, options *options, tickInterval time.Duration) *endpointsRegistry {
 	var er endpointsRegistry
 	er.backgroundCtx, er.stop = context.WithCancel(backgroundCtx)
 	er.k8sClient = k8sClient
-	go er.tick(tickInterval)
+	er.options = options
+	er.tickInterval = tickInterval
+	for endpointKey, trafficSplit := range options.TrafficSplits {
+		er.SetTrafficSplit(endpointKey.Namespace, endpointKey.EndpointsName, trafficSplit)
+	}
+	for endpointKey, failoverGroup := range options.FailoverGroups {
+		er.SetFailoverGroup(endpointKey.Namespace, endpointKey.EndpointsName, failoverGroup)
+	}
+	go er.tick()
 	return &er
 }
 
-func (er *endpointsRegistry) tick(tickInterval time.Duration) {
-	ticker := time.NewTicker(tickInterval)
+func (er *endpointsRegistry) tick() {
+	ticker := time.NewTicker(er.tickInterval)
 	defer ticker.Stop()
 	for {
 		select {
@@ -33,15 +58,22 @@ func (er *endpointsRegistry) tick(tickInterval time.Duration) {
 			return
 		case <-ticker.C:
 			er.evictIPAddressesCache()
+			er.expireSyncStates()
 		}
 	}
 }
 
 func (er *endpointsRegistry) evictIPAddressesCache() {
-	er.ipAddressesCache.Range(func(_, value interface{}) bool {
+	er.ipAddressesCache.Range(func(key, value interface{}) bool {
 		if cachedIPAddresses, ok := value.(*cachedIPAddresses); ok {
+			endpointKey := key.(endpointKey)
+			if er.hasSubscribers(endpointKey) {
+				return true
+			}
 			if hitCount := atomic.LoadInt64(&cachedIPAddresses.HitCount); hitCount == 0 {
 				cachedIPAddresses.Source.Stop()
+				er.options.MetricsCollector.AddCounter(MetricCacheEvictions, endpointKey.Labels(), 1)
+				er.options.Logger.Debug("evicting unused endpoints", endpointKey.LogArgs()...)
 			} else {
 				atomic.CompareAndSwapInt64(&cachedIPAddresses.HitCount, hitCount, 0)
 			}
@@ -50,7 +82,26 @@ func (er *endpointsRegistry) evictIPAddressesCache() {
 	})
 }
 
+func (er *endpointsRegistry) DefaultNamespace() string { return er.k8sClient.Namespace() }
+
 func (er *endpointsRegistry) GetIPAddresses(ctx context.Context, namespace string, endpointsName string) ([]string, error) {
+	ipAddresses, _, err := er.LookUpIPAddresses(ctx, namespace, endpointsName)
+	return ipAddresses, err
+}
+
+// LookUpIPAddresses likes GetIPAddresses but also reports whether the ip addresses are
+// served from the cache.
+func (er *endpointsRegistry) LookUpIPAddresses(ctx context.Context, namespace string, endpointsName string) ([]string, bool, error) {
+	return er.lookUpIPAddresses(ctx, namespace, endpointsName, true)
+}
+
+// PeekIPAddresses likes LookUpIPAddresses but does not count cache hits, for the endpoints
+// which are looked at but not necessarily used, so that unused endpoints can still expire.
+func (er *endpointsRegistry) PeekIPAddresses(ctx context.Context, namespace string, endpointsName string) ([]string, bool, error) {
+	return er.lookUpIPAddresses(ctx, namespace, endpointsName, false)
+}
+
+func (er *endpointsRegistry) lookUpIPAddresses(ctx context.Context, namespace string, endpointsName string, countHit bool) ([]string, bool, error) {
 	if namespace == "" {
 		namespace = er.k8sClient.Namespace()
 	}
@@ -65,33 +116,142 @@ func (er *endpointsRegistry) GetIPAddresses(ctx context.Context, namespace strin
 	}
 	switch value := value.(type) {
 	case *getIPAddressesResults:
+		er.options.MetricsCollector.AddCounter(MetricCacheMisses, endpointKey.Labels(), 1)
 		results := value
 		select {
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			return nil, false, ctx.Err()
 		case <-results.Waiter:
-			return results.IPAddresses, results.Err
+			return results.IPAddresses, false, results.Err
 		}
 	case *cachedIPAddresses:
 		cachedIPAddresses := value
-		atomic.AddInt64(&cachedIPAddresses.HitCount, 1)
-		return cachedIPAddresses.Value, nil
+		if countHit {
+			er.countHit(endpointKey, cachedIPAddresses)
+		}
+		return cachedIPAddresses.Value, true, nil
 	default:
 		panic("unreachable code")
 	}
 }
 
+// CountHit counts a cache hit of the endpoints with the given namespace and name, which were
+// peeked at, if they are cached, so that they are not evicted while in use.
+func (er *endpointsRegistry) CountHit(namespace string, endpointsName string) {
+	if namespace == "" {
+		namespace = er.k8sClient.Namespace()
+	}
+	endpointKey := endpointKey{namespace, endpointsName}
+	if value, ok := er.ipAddressesCache.Load(endpointKey); ok {
+		if cachedIPAddresses, ok := value.(*cachedIPAddresses); ok {
+			er.countHit(endpointKey, cachedIPAddresses)
+		}
+	}
+}
+
+func (er *endpointsRegistry) countHit(endpointKey endpointKey, cachedIPAddresses *cachedIPAddresses) {
+	er.options.MetricsCollector.AddCounter(MetricCacheHits, endpointKey.Labels(), 1)
+	atomic.AddInt64(&cachedIPAddresses.HitCount, 1)
+}
+
+// getCachedIPAddresses returns the cached ip addresses of the given endpoints, or nil if
+// they are not cached.
+func (er *endpointsRegistry) getCachedIPAddresses(namespace string, endpointsName string) *cachedIPAddresses {
+	if namespace == "" {
+		namespace = er.k8sClient.Namespace()
+	}
+	value, ok := er.ipAddressesCache.Load(endpointKey{namespace, endpointsName})
+	if !ok {
+		return nil
+	}
+	cachedIPAddresses, _ := value.(*cachedIPAddresses)
+	return cachedIPAddresses
+}
+
+// GetHostnameIPAddress returns the ip address of the endpoint with the given hostname (or
+// pod name) in the cached endpoints, and false if there is no such endpoint.
+func (er *endpointsRegistry) GetHostnameIPAddress(namespace string, endpointsName string, hostname string) (string, bool) {
+	if namespace == "" {
+		namespace = er.k8sClient.Namespace()
+	}
+	value, ok := er.ipAddressesCache.Load(endpointKey{namespace, endpointsName})
+	if !ok {
+		return "", false
+	}
+	cachedIPAddresses, ok := value.(*cachedIPAddresses)
+	if !ok {
+		return "", false
+	}
+	ipAddress, ok := cachedIPAddresses.Hostnames[hostname]
+	return ipAddress, ok
+}
+
+// AddRemovalCallback adds the given callback to be called with the ip addresses removed from
+// the endpoints, after the endpoints are updated, and returns a function to remove the
+// callback. The ip addresses may still be in use by other endpoints, see IsIPAddressInUse.
+func (er *endpointsRegistry) AddRemovalCallback(removalCallback ipAddressesRemovalCallback) func() {
+	callback := &removalCallback
+	er.lock.Lock()
+	er.removalCallbacks = append(er.removalCallbacks, callback)
+	er.lock.Unlock()
+	return func() { er.removeRemovalCallback(callback) }
+}
+
+func (er *endpointsRegistry) removeRemovalCallback(callback *ipAddressesRemovalCallback) {
+	er.lock.Lock()
+	defer er.lock.Unlock()
+	// Copy on write, as notifyRemoval calls the callbacks without the lock.
+	removalCallbacks := make([]*ipAddressesRemovalCallback, 0, len(er.removalCallbacks))
+	for _, otherCallback := range er.removalCallbacks {
+		if otherCallback != callback {
+			removalCallbacks = append(removalCallbacks, otherCallback)
+		}
+	}
+	er.removalCallbacks = removalCallbacks
+}
+
 func (er *endpointsRegistry) doGetIPAddresses(endpointKey endpointKey, results *getIPAddressesResults) {
+	if value, ok := er.watchFailures.Load(endpointKey); ok {
+		er.options.MetricsCollector.AddCounter(MetricWatchRestarts, endpointKey.Labels(), 1)
+		er.options.Logger.Debug("restarting endpoints watch", endpointKey.LogArgs("failureCount", value.(*watchFailure).Count)...)
+	}
+	var oldIPAddresses []string
 	ipAddressesCallback := func(ipAddressesSource *ipAddressesSource, ipAddresses []string, err error) {
 		if err == nil {
+			er.watchFailures.Delete(endpointKey)
+			er.recordSync(endpointKey)
+			er.options.MetricsCollector.SetGauge(MetricAddresses, endpointKey.Labels(), float64(len(ipAddresses)))
+			removedIPAddresses := diffIPAddresses(oldIPAddresses, ipAddresses)
+			oldIPAddresses = ipAddresses
 			cachedIPAddresses := cachedIPAddresses{
-				Source:   ipAddressesSource,
-				Value:    ipAddresses,
-				HitCount: 1,
+				Source:           ipAddressesSource,
+				Value:            ipAddresses,
+				NotReadyValue:    ipAddressesSource.NotReadyValue(),
+				TerminatingValue: ipAddressesSource.TerminatingValue(),
+				ResourceVersion:  ipAddressesSource.ResourceVersion(),
+				Hostnames:        ipAddressesSource.Hostnames(),
+				UpdateTime:       time.Now(),
+				HitCount:         1,
+
+				NodeNames:          ipAddressesSource.NodeNames(),
+				EndpointTopologies: ipAddressesSource.EndpointTopologies(),
+				PodRefs:            ipAddressesSource.PodRefs(),
 			}
 			er.ipAddressesCache.Store(endpointKey, &cachedIPAddresses)
+			if len(removedIPAddresses) >= 1 {
+				er.notifyRemoval(endpointKey, removedIPAddresses)
+			}
+			er.publishSnapshot(cachedIPAddresses.Snapshot(endpointKey))
 		} else {
 			er.ipAddressesCache.Delete(endpointKey)
+			er.options.MetricsCollector.SetGauge(MetricAddresses, endpointKey.Labels(), 0)
+			if !ipAddressesSource.IsStopped() {
+				er.recordWatchFailure(endpointKey, ipAddressesSource.ResourceVersion(), err)
+				er.recordSyncFailure(endpointKey, err)
+			}
+			if er.hasSubscribers(endpointKey) {
+				er.resubscribe(endpointKey)
+			}
 		}
 		if results != nil {
 			results.IPAddresses, results.Err = ipAddresses, err
@@ -99,7 +259,73 @@ func (er *endpointsRegistry) doGetIPAddresses(endpointKey endpointKey, results *
 			results = nil
 		}
 	}
-	newIPAddressesSource(er.backgroundCtx, er.k8sClient, endpointKey.Namespace, endpointKey.EndpointsName, ipAddressesCallback)
+	newIPAddressesSource(er.backgroundCtx, er.k8sClient, er.options, endpointKey.Namespace, endpointKey.EndpointsName, er.getEventHistory(endpointKey), ipAddressesCallback)
+}
+
+// persistentWatchFailures is the number of consecutive watch failures, without getting the
+// endpoints successfully in between, which are considered persistent and logged as warnings.
+const persistentWatchFailures = 3
+
+type watchFailure struct {
+	Count int
+	Time  time.Time
+}
+
+func (er *endpointsRegistry) recordWatchFailure(endpointKey endpointKey, resourceVersion string, err error) {
+	failureCount := 1
+	if value, ok := er.watchFailures.Load(endpointKey); ok {
+		failureCount += value.(*watchFailure).Count
+	}
+	er.watchFailures.Store(endpointKey, &watchFailure{failureCount, time.Now()})
+	logArgs := endpointKey.LogArgs("resourceVersion", resourceVersion, "error", err, "failureCount", failureCount)
+	if failureCount < persistentWatchFailures {
+		er.options.Logger.Debug("endpoints watch failed", logArgs...)
+	} else {
+		er.options.Logger.Warn("endpoints watch failed persistently", logArgs...)
+	}
+}
+
+func diffIPAddresses(oldIPAddresses []string, newIPAddresses []string) []string {
+	if len(oldIPAddresses) == 0 {
+		return nil
+	}
+	newIPAddressSet := make(map[string]struct{}, len(newIPAddresses))
+	for _, ipAddress := range newIPAddresses {
+		newIPAddressSet[ipAddress] = struct{}{}
+	}
+	var removedIPAddresses []string
+	for _, ipAddress := range oldIPAddresses {
+		if _, ok := newIPAddressSet[ipAddress]; !ok {
+			removedIPAddresses = append(removedIPAddresses, ipAddress)
+		}
+	}
+	return removedIPAddresses
+}
+
+func (er *endpointsRegistry) notifyRemoval(endpointKey endpointKey, removedIPAddresses []string) {
+	er.lock.Lock()
+	removalCallbacks := er.removalCallbacks
+	er.lock.Unlock()
+	for _, removalCallback := range removalCallbacks {
+		(*removalCallback)(endpointKey, removedIPAddresses)
+	}
+}
+
+// IsIPAddressInUse reports whether any of the cached endpoints contains the given ip address,
+// ready or not, e.g. as a pod can be behind more than one service.
+func (er *endpointsRegistry) IsIPAddressInUse(ipAddress string) bool {
+	inUse := false
+	er.ipAddressesCache.Range(func(_, value interface{}) bool {
+		cachedIPAddresses, ok := value.(*cachedIPAddresses)
+		if !ok {
+			return true
+		}
+		inUse = containsIPAddress(cachedIPAddresses.Value, ipAddress) ||
+			containsIPAddress(cachedIPAddresses.NotReadyValue, ipAddress) ||
+			containsIPAddress(cachedIPAddresses.TerminatingValue, ipAddress)
+		return !inUse
+	})
+	return inUse
 }
 
 func (er *endpointsRegistry) Stop() { er.stop() }
@@ -109,6 +335,12 @@ type endpointKey struct {
 	EndpointsName string
 }
 
+func (ek endpointKey) Labels() []Label { return endpointsLabels(ek.Namespace, ek.EndpointsName) }
+
+func (ek endpointKey) LogArgs(args ...interface{}) []interface{} {
+	return append([]interface{}{"namespace", ek.Namespace, "endpointsName", ek.EndpointsName}, args...)
+}
+
 type getIPAddressesResults struct {
 	Waiter      chan struct{}
 	IPAddresses []string
@@ -116,7 +348,18 @@ type getIPAddressesResults struct {
 }
 
 type cachedIPAddresses struct {
-	Source   *ipAddressesSource
-	Value    []string
-	HitCount int64
+	Source           *ipAddressesSource
+	Value            []string
+	NotReadyValue    []string
+	TerminatingValue []string
+	ResourceVersion  string
+	Hostnames        map[string]string
+	UpdateTime       time.Time
+	HitCount         int64
+
+	// NodeNames, EndpointTopologies and PodRefs are by ip address, per endpoints, as a pod
+	// can be behind more than one service.
+	NodeNames          map[string]string
+	EndpointTopologies map[string]endpointTopology
+	PodRefs            map[string]podRef
 }
@@ -20,6 +20,7 @@ func TestEndpointsRegistry_GetIPAddresses(t *testing.T) {
 		Init struct {
 			BackgroundCtx context.Context
 			MockK8sClient *mock_k8sclient.MockK8sClient
+			Options       Options
 			TickInterval  time.Duration
 		}
 		In struct {
@@ -41,11 +42,12 @@ func TestEndpointsRegistry_GetIPAddresses(t *testing.T) {
 			w.Init.BackgroundCtx = context.Background()
 			ctrl := gomock.NewController(t)
 			w.Init.MockK8sClient = mock_k8sclient.NewMockK8sClient(ctrl)
+			w.Init.Options = MakeOptions(nil)
 			w.Init.TickInterval = 24 * time.Hour
 			w.In.Ctx = context.Background()
 		}).
 		Step(1, func(t *testing.T, w *Workspace) {
-			w.ER = NewEndpointsRegistry(w.Init.BackgroundCtx, w.Init.MockK8sClient, w.Init.TickInterval)
+			w.ER = NewEndpointsRegistry(w.Init.BackgroundCtx, w.Init.MockK8sClient, &w.Init.Options, w.Init.TickInterval)
 			t.Cleanup(w.ER.Stop)
 		}).
 		Step(2, func(t *testing.T, w *Workspace) {
@@ -249,5 +251,202 @@ func TestEndpointsRegistry_GetIPAddresses(t *testing.T) {
 				w.ExpOut.Err = context.DeadlineExceeded
 				w.ExpOut.ErrStr = context.DeadlineExceeded.Error()
 			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.MockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("foo"), gomock.Eq("bar")).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+						return nil, errors.New("something wrong")
+					}).Times(3)
+				logger := new(recordingLogger)
+				w.Init.Options.Logger = logger
+				t.Cleanup(func() {
+					assert.Equal(t, []string{
+						"DEBUG endpoints watch failed",
+						"DEBUG restarting endpoints watch",
+						"DEBUG endpoints watch failed",
+						"DEBUG restarting endpoints watch",
+						"WARN endpoints watch failed persistently",
+					}, logger.Records())
+				})
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				for i := 0; i < 2; i++ {
+					_, err := w.ER.GetIPAddresses(context.Background(), "foo", "bar")
+					if !assert.Error(t, err) {
+						t.FailNow()
+					}
+				}
+				w.In.Namespace = "foo"
+				w.In.EndpointsName = "bar"
+				w.ExpOut.ErrStr = "get endpoints; namespace=\"foo\" endpointsName=\"bar\": something wrong"
+			}),
 	)
 }
+
+type recordingLogger struct {
+	lock    sync.Mutex
+	records []string
+}
+
+var _ Logger = (*recordingLogger)(nil)
+
+func (rl *recordingLogger) Debug(msg string, _ ...interface{}) { rl.record("DEBUG " + msg) }
+func (rl *recordingLogger) Info(msg string, _ ...interface{})  { rl.record("INFO " + msg) }
+func (rl *recordingLogger) Warn(msg string, _ ...interface{})  { rl.record("WARN " + msg) }
+func (rl *recordingLogger) Error(msg string, _ ...interface{}) { rl.record("ERROR " + msg) }
+
+func (rl *recordingLogger) record(record string) {
+	rl.lock.Lock()
+	rl.records = append(rl.records, record)
+	rl.lock.Unlock()
+}
+
+func (rl *recordingLogger) Records() []string {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	return append([]string(nil), rl.records...)
+}
+
+func TestEndpointsRegistry_Subscribe(t *testing.T) {
+	type Workspace struct {
+		Init struct {
+			MockK8sClient *mock_k8sclient.MockK8sClient
+			Options       Options
+			TickInterval  time.Duration
+		}
+		In struct {
+			Namespace     string
+			EndpointsName string
+		}
+		ExpOut, ActOut struct {
+			LastSnapshot Snapshot
+		}
+
+		ER        *EndpointsRegistry
+		Snapshots chan Snapshot
+	}
+	tc := testcase.New().
+		Step(0, func(t *testing.T, w *Workspace) {
+			ctrl := gomock.NewController(t)
+			w.Init.MockK8sClient = mock_k8sclient.NewMockK8sClient(ctrl)
+			w.Init.Options = MakeOptions(nil)
+			w.Init.TickInterval = 24 * time.Hour
+		}).
+		Step(1, func(t *testing.T, w *Workspace) {
+			w.ER = NewEndpointsRegistry(context.Background(), w.Init.MockK8sClient, &w.Init.Options, w.Init.TickInterval)
+			t.Cleanup(w.ER.Stop)
+		}).
+		Step(2, func(t *testing.T, w *Workspace) {
+			w.Snapshots = make(chan Snapshot, 100)
+			unsubscribe := w.ER.Subscribe(w.In.Namespace, w.In.EndpointsName, func(snapshot Snapshot) {
+				w.Snapshots <- snapshot
+			})
+			t.Cleanup(unsubscribe)
+			var resourceVersions []string
+			timeout := time.After(5 * time.Second)
+			for w.ActOut.LastSnapshot.ResourceVersion != w.ExpOut.LastSnapshot.ResourceVersion {
+				select {
+				case snapshot := <-w.Snapshots:
+					resourceVersions = append(resourceVersions, snapshot.ResourceVersion)
+					w.ActOut.LastSnapshot = snapshot
+				case <-timeout:
+					t.Fatal("timed out")
+				}
+			}
+			assert.IsIncreasing(t, resourceVersions)
+		}).
+		Step(3, func(t *testing.T, w *Workspace) {
+			assert.False(t, w.ActOut.LastSnapshot.LastUpdateTime.IsZero())
+			w.ActOut.LastSnapshot.LastUpdateTime = time.Time{}
+			assert.NotEmpty(t, w.ActOut.LastSnapshot.History)
+			w.ActOut.LastSnapshot.History = nil
+			assert.Equal(t, w.ExpOut, w.ActOut)
+		})
+	testcase.RunListParallel(t,
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.TickInterval = 10 * time.Millisecond
+				w.Init.MockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("foo"), gomock.Eq("bar")).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+						return &k8sclient.Endpoints{
+							Metadata: k8sclient.Metadata{ResourceVersion: "1"},
+							Subsets: []k8sclient.EndpointSubset{
+								{Addresses: []k8sclient.EndpointAddress{{IP: "1.2.3.4"}}},
+							},
+						}, nil
+					})
+				w.Init.MockK8sClient.EXPECT().WatchEndpoints(gomock.Any(), gomock.Eq("foo"), gomock.Eq("bar"), gomock.Eq("1"), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName, resourceVersion string, callback k8sclient.WatchEndpointsCallback) error {
+						callback(k8sclient.EventModified, &k8sclient.Endpoints{
+							Metadata: k8sclient.Metadata{ResourceVersion: "2"},
+							Subsets: []k8sclient.EndpointSubset{
+								{Addresses: []k8sclient.EndpointAddress{{IP: "1.2.3.4"}, {IP: "2.3.4.5"}}},
+							},
+						})
+						callback(k8sclient.EventModified, &k8sclient.Endpoints{
+							Metadata: k8sclient.Metadata{ResourceVersion: "3"},
+							Subsets: []k8sclient.EndpointSubset{
+								{Addresses: []k8sclient.EndpointAddress{{IP: "2.3.4.5"}}},
+							},
+						})
+						<-ctx.Done()
+						return ctx.Err()
+					})
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				w.In.Namespace = "foo"
+				w.In.EndpointsName = "bar"
+				w.ExpOut.LastSnapshot = Snapshot{
+					Namespace:       "foo",
+					EndpointsName:   "bar",
+					IPAddresses:     []string{"2.3.4.5"},
+					ResourceVersion: "3",
+					HitCount:        1,
+					WatchState:      WatchStateWatching,
+				}
+			}).
+			Step(2.5, func(t *testing.T, w *Workspace) {
+				// Subscribed endpoints must survive eviction; GetEndpoints is expected once only.
+				time.Sleep(5 * w.Init.TickInterval)
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.MockK8sClient.EXPECT().Namespace().Return("foo")
+				w.Init.MockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("foo"), gomock.Eq("bar")).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+						return nil, nil
+					})
+				w.Init.MockK8sClient.EXPECT().WatchEndpoints(gomock.Any(), gomock.Eq("foo"), gomock.Eq("bar"), gomock.Eq(""), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName, resourceVersion string, callback k8sclient.WatchEndpointsCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				w.In.EndpointsName = "bar"
+				w.ActOut.LastSnapshot.ResourceVersion = "-"
+				w.ExpOut.LastSnapshot = Snapshot{
+					Namespace:     "foo",
+					EndpointsName: "bar",
+					HitCount:      1,
+					WatchState:    WatchStateWatching,
+				}
+			}),
+	)
+}
+
+func TestSubscriptionRetryDelay(t *testing.T) {
+	for _, c := range []struct {
+		FailureCount int
+		Delay        time.Duration
+	}{
+		{0, 1 * time.Second},
+		{1, 1 * time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{7, 1 * time.Minute},
+		{100, 1 * time.Minute},
+	} {
+		assert.Equal(t, c.Delay, SubscriptionRetryDelay(c.FailureCount), "failureCount=%d", c.FailureCount)
+	}
+}
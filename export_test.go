@@ -1,13 +1,53 @@
 package kubetransport
 
+import "net/http"
+
 type IPAddressesSource = ipAddressesSource
 
 var NewIPAddressesSource = newIPAddressesSource
 
+const MinWatchDuration = minWatchDuration
+
+type EventHistory = eventHistory
+
+var NewEventHistory = newEventHistory
+
 type EndpointsRegistry = endpointsRegistry
 
 var NewEndpointsRegistry = newEndpointsRegistry
 
+var SubscriptionRetryDelay = subscriptionRetryDelay
+
+func (er *endpointsRegistry) RemovalCallbackCount() int {
+	er.lock.Lock()
+	defer er.lock.Unlock()
+	return len(er.removalCallbacks)
+}
+
 type KubeTransport = kubeTransport
 
+type IdleConnectionCloser = idleConnectionCloser
+
+var NewIdleConnectionCloser = newIdleConnectionCloser
+
 var NewKubeTransport = newKubeTransport
+
+type StateRegistry = stateRegistry
+
+var NewStateRegistry = newStateRegistry
+
+type Options = options
+
+var MakeOptions = makeOptions
+
+type CircuitBreakerState = circuitBreakerState
+
+const (
+	CircuitBreakerClosed   = circuitBreakerClosed
+	CircuitBreakerOpen     = circuitBreakerOpen
+	CircuitBreakerHalfOpen = circuitBreakerHalfOpen
+)
+
+func NewDebugHandler(er *endpointsRegistry) http.Handler { return debugHandler{er} }
+
+func NewHealthHandler(er *endpointsRegistry) http.Handler { return healthHandler{er} }
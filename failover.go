@@ -0,0 +1,134 @@
+package kubetransport
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// FailoverGroup is a list of services in priority order behind a logical service. Requests
+// for the logical service are sent to the first service which has available endpoints (pods),
+// so the traffic fails over to the standby services when the preceding ones have no ready or
+// healthy endpoint, and fails back once they have again.
+type FailoverGroup struct {
+	Services []FailoverService
+}
+
+// FailoverService is a service of a failover group.
+type FailoverService struct {
+	// Namespace is the namespace of the service. Empty means the namespace of the logical
+	// service.
+	Namespace string
+
+	EndpointsName string
+}
+
+// WithFailoverGroup sets the failover group for the logical service with the given namespace
+// and name. Empty namespace means the namespace of the client. Requests failed over are
+// reported by ResolutionInfo.FailedOver.
+func WithFailoverGroup(namespace string, endpointsName string, failoverGroup FailoverGroup) Option {
+	return func(options *options) {
+		if options.FailoverGroups == nil {
+			options.FailoverGroups = make(map[endpointKey]FailoverGroup)
+		}
+		options.FailoverGroups[endpointKey{namespace, endpointsName}] = failoverGroup
+	}
+}
+
+type failoverGroupState struct {
+	Namespace     string
+	EndpointsName string
+	Services      []endpointKey
+
+	activeIndex int32
+}
+
+func (er *endpointsRegistry) SetFailoverGroup(namespace string, endpointsName string, failoverGroup FailoverGroup) {
+	if namespace == "" {
+		namespace = er.k8sClient.Namespace()
+	}
+	var fgs failoverGroupState
+	fgs.Namespace = namespace
+	fgs.EndpointsName = endpointsName
+	for _, service := range failoverGroup.Services {
+		if service.Namespace == "" {
+			service.Namespace = namespace
+		}
+		fgs.Services = append(fgs.Services, endpointKey{service.Namespace, service.EndpointsName})
+	}
+	er.failoverGroups.Store(endpointKey{namespace, endpointsName}, &fgs)
+}
+
+// failOver returns the namespace and the name of the service to send a request for the given
+// logical service to, whether it is a standby service of the failover group, and whether
+// the endpoints of the services looked at are all served from the cache. If there is no
+// failover group, the given namespace and name are returned.
+func (kt *kubeTransport) failOver(ctx context.Context, namespace string, endpointsName string) (string, string, bool, bool) {
+	value, ok := kt.endpointsRegistry.failoverGroups.Load(endpointKey{namespace, endpointsName})
+	if !ok {
+		return namespace, endpointsName, false, true
+	}
+	failoverGroup := value.(*failoverGroupState)
+	if len(failoverGroup.Services) == 0 {
+		return namespace, endpointsName, false, true
+	}
+	activeIndex := -1
+	firstResolvedIndex := -1
+	allCacheHit := true
+	cacheHits := make([]bool, len(failoverGroup.Services))
+	for i, service := range failoverGroup.Services {
+		// The endpoints are only peeked at, as those of the service picked are looked up again
+		// for the request, see below for the ones before it.
+		ipAddresses, cacheHit, err := kt.endpointsRegistry.PeekIPAddresses(ctx, service.Namespace, service.EndpointsName)
+		allCacheHit = allCacheHit && cacheHit
+		cacheHits[i] = cacheHit
+		if err != nil || len(ipAddresses) == 0 {
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		if firstResolvedIndex < 0 {
+			firstResolvedIndex = i
+		}
+		if kt.hasHealthyEndpoint(ipAddresses) {
+			activeIndex = i
+			break
+		}
+	}
+	if activeIndex < 0 {
+		// No service has healthy endpoints, use the first one having any endpoints, or else
+		// let the primary one fail.
+		activeIndex = firstResolvedIndex
+		if activeIndex < 0 {
+			activeIndex = 0
+		}
+	}
+	if oldActiveIndex := int(atomic.SwapInt32(&failoverGroup.activeIndex, int32(activeIndex))); oldActiveIndex != activeIndex {
+		service := failoverGroup.Services[activeIndex]
+		logArgs := []interface{}{"namespace", failoverGroup.Namespace, "endpointsName", failoverGroup.EndpointsName,
+			"toNamespace", service.Namespace, "toEndpointsName", service.EndpointsName, "priority", activeIndex}
+		if activeIndex > oldActiveIndex {
+			kt.options.Logger.Warn("failing over", logArgs...)
+		} else {
+			kt.options.Logger.Info("failing back", logArgs...)
+		}
+	}
+	// The services before the one picked are still in use, to fail back to, so their cache
+	// hits are counted to keep them from being evicted. Those after it are not.
+	for i, service := range failoverGroup.Services[:activeIndex] {
+		if cacheHits[i] {
+			kt.endpointsRegistry.CountHit(service.Namespace, service.EndpointsName)
+		}
+	}
+	service := failoverGroup.Services[activeIndex]
+	return service.Namespace, service.EndpointsName, activeIndex >= 1, allCacheHit
+}
+
+func (kt *kubeTransport) hasHealthyEndpoint(ipAddresses []string) bool {
+	for _, ipAddress := range ipAddresses {
+		if kt.stateRegistry.GetEndpointState(ipAddress).IsHealthy() {
+			return true
+		}
+	}
+	return false
+}
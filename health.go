@@ -0,0 +1,172 @@
+package kubetransport
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Health is the health of the endpoints discovery.
+type Health struct {
+	// Healthy is false if any threshold of HealthThresholds is exceeded.
+	Healthy bool `json:"healthy"`
+
+	// ActiveWatches is the number of the endpoints being watched.
+	ActiveWatches int `json:"activeWatches"`
+
+	// RetryingWatches is the number of the endpoints whose watches have failed a few times.
+	RetryingWatches int `json:"retryingWatches"`
+
+	// FailingWatches is the number of the endpoints whose watches have failed persistently.
+	FailingWatches int `json:"failingWatches"`
+
+	// OldestSyncAge is the time elapsed since the last successful sync of the stalest
+	// endpoints. The endpoints being watched are considered in sync.
+	OldestSyncAge time.Duration `json:"oldestSyncAge"`
+
+	// APIServerReachable is false if the last request to the API server failed with a
+	// transport error, e.g. a connection failure or a timeout.
+	APIServerReachable bool `json:"apiServerReachable"`
+
+	// UnreachableDuration is how long the API server has been unreachable.
+	UnreachableDuration time.Duration `json:"unreachableDuration"`
+}
+
+// HealthThresholds are the thresholds beyond which the endpoints discovery is unhealthy.
+type HealthThresholds struct {
+	// MaxSyncAge is the maximum of Health.OldestSyncAge. Zero means no limit.
+	MaxSyncAge time.Duration
+
+	// MaxUnreachableDuration is the maximum of Health.UnreachableDuration.
+	// Zero means no limit.
+	MaxUnreachableDuration time.Duration
+}
+
+// WithHealthThresholds sets the thresholds for Health. Defaults to 10 minutes for both.
+func WithHealthThresholds(healthThresholds HealthThresholds) Option {
+	return func(options *options) { options.HealthThresholds = healthThresholds }
+}
+
+// Health returns the health of the endpoints discovery.
+func (tw *TransportWrapper) Health() Health { return tw.endpointsRegistry.Health() }
+
+// HealthHandler returns an http.Handler serving the health of the endpoints discovery as
+// JSON, with the status code 200 if healthy or 503 otherwise, e.g. for readiness probes.
+func (tw *TransportWrapper) HealthHandler() http.Handler {
+	return healthHandler{tw.endpointsRegistry}
+}
+
+func (er *endpointsRegistry) Health() Health {
+	now := time.Now()
+	var health Health
+	countedEndpointKeys := make(map[endpointKey]struct{})
+	er.ipAddressesCache.Range(func(key, value interface{}) bool {
+		if cachedIPAddresses, ok := value.(*cachedIPAddresses); ok && !cachedIPAddresses.Source.IsStopped() {
+			health.ActiveWatches++
+			countedEndpointKeys[key.(endpointKey)] = struct{}{}
+		}
+		return true
+	})
+	er.watchFailures.Range(func(key, value interface{}) bool {
+		endpointKey := key.(endpointKey)
+		if _, ok := countedEndpointKeys[endpointKey]; ok {
+			return true
+		}
+		if value.(*watchFailure).Count < persistentWatchFailures {
+			health.RetryingWatches++
+		} else {
+			health.FailingWatches++
+		}
+		if value, ok := er.lastSyncTimes.Load(endpointKey); ok {
+			if syncAge := now.Sub(value.(time.Time)); syncAge > health.OldestSyncAge {
+				health.OldestSyncAge = syncAge
+			}
+		}
+		return true
+	})
+	er.apiServerLock.Lock()
+	health.APIServerReachable = er.unreachableSince.IsZero()
+	if !health.APIServerReachable {
+		health.UnreachableDuration = now.Sub(er.unreachableSince)
+	}
+	er.apiServerLock.Unlock()
+	healthThresholds := er.options.HealthThresholds
+	health.Healthy = (healthThresholds.MaxSyncAge == 0 || health.OldestSyncAge <= healthThresholds.MaxSyncAge) &&
+		(healthThresholds.MaxUnreachableDuration == 0 || health.UnreachableDuration <= healthThresholds.MaxUnreachableDuration)
+	return health
+}
+
+func (er *endpointsRegistry) recordSync(endpointKey endpointKey) {
+	er.lastSyncTimes.Store(endpointKey, time.Now())
+	er.apiServerLock.Lock()
+	er.unreachableSince = time.Time{}
+	er.apiServerLock.Unlock()
+}
+
+func (er *endpointsRegistry) recordSyncFailure(endpointKey endpointKey, err error) {
+	now := time.Now()
+	// For the endpoints never synced, the staleness starts from the first failure.
+	er.lastSyncTimes.LoadOrStore(endpointKey, now)
+	er.apiServerLock.Lock()
+	// Only the transport errors tell the API server is unreachable, while the other errors,
+	// e.g. 403 for the lack of the permission to watch the endpoints in a namespace, tell
+	// the opposite.
+	if netError := net.Error(nil); errors.As(err, &netError) {
+		if er.unreachableSince.IsZero() {
+			er.unreachableSince = now
+		}
+	} else {
+		er.unreachableSince = time.Time{}
+	}
+	er.apiServerLock.Unlock()
+}
+
+// expireSyncStates forgets the sync states, and the event histories, of the endpoints which
+// are no longer watched and have not failed in the last tick interval, as they are only
+// restarted on demand, so that the endpoints no longer used do not affect the health
+// forever, nor pile up.
+func (er *endpointsRegistry) expireSyncStates() {
+	now := time.Now()
+	er.lastSyncTimes.Range(func(key, _ interface{}) bool {
+		if er.isSyncStateExpired(key, now) {
+			er.watchFailures.Delete(key)
+			er.lastSyncTimes.Delete(key)
+		}
+		return true
+	})
+	er.eventHistories.Range(func(key, _ interface{}) bool {
+		if er.isSyncStateExpired(key, now) {
+			er.eventHistories.Delete(key)
+		}
+		return true
+	})
+}
+
+func (er *endpointsRegistry) isSyncStateExpired(key interface{}, now time.Time) bool {
+	if _, ok := er.ipAddressesCache.Load(key); ok {
+		return false
+	}
+	if value, ok := er.watchFailures.Load(key); ok && now.Sub(value.(*watchFailure).Time) < er.tickInterval {
+		return false
+	}
+	return true
+}
+
+type healthHandler struct {
+	endpointsRegistry *endpointsRegistry
+}
+
+func (hh healthHandler) ServeHTTP(responseWriter http.ResponseWriter, _ *http.Request) {
+	health := hh.endpointsRegistry.Health()
+	responseWriter.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if health.Healthy {
+		responseWriter.WriteHeader(http.StatusOK)
+	} else {
+		responseWriter.WriteHeader(http.StatusServiceUnavailable)
+	}
+	encoder := json.NewEncoder(responseWriter)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(health)
+}
@@ -0,0 +1,175 @@
+package kubetransport_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/go-tk/kubetransport"
+	"github.com/go-tk/kubetransport/internal/k8sclient"
+	mock_k8sclient "github.com/go-tk/kubetransport/internal/k8sclient/mock"
+	"github.com/go-tk/testcase"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpointsRegistry_Health(t *testing.T) {
+	type Workspace struct {
+		Init struct {
+			MockK8sClient *mock_k8sclient.MockK8sClient
+			Options       Options
+			TickInterval  time.Duration
+			WatchExpiry   bool
+			Err           error
+		}
+		ExpOut, ActOut struct {
+			Health     Health
+			StatusCode int
+		}
+
+		ER         *EndpointsRegistry
+		WatchCount int32
+	}
+	tc := testcase.New().
+		Step(0, func(t *testing.T, w *Workspace) {
+			ctrl := gomock.NewController(t)
+			w.Init.MockK8sClient = mock_k8sclient.NewMockK8sClient(ctrl)
+			w.Init.Options = MakeOptions(nil)
+			w.Init.TickInterval = 24 * time.Hour
+			w.Init.Err = errors.New("something wrong")
+			w.Init.MockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("foo"), gomock.Eq("bar")).
+				DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+					return &k8sclient.Endpoints{
+						Metadata: k8sclient.Metadata{ResourceVersion: "8910"},
+						Subsets: []k8sclient.EndpointSubset{
+							{Addresses: []k8sclient.EndpointAddress{{IP: "1.2.3.4"}}},
+						},
+					}, nil
+				})
+			w.Init.MockK8sClient.EXPECT().WatchEndpoints(gomock.Any(), gomock.Eq("foo"), gomock.Eq("bar"), gomock.Eq("8910"), gomock.Any()).
+				DoAndReturn(func(ctx context.Context, namespace, endpointsName, resourceVersion string, callback k8sclient.WatchEndpointsCallback) error {
+					if atomic.AddInt32(&w.WatchCount, 1) == 1 && w.Init.WatchExpiry {
+						select {
+						case <-ctx.Done():
+							return ctx.Err()
+						case <-time.After(MinWatchDuration):
+							return fmt.Errorf("decode event json: %w", io.EOF)
+						}
+					}
+					<-ctx.Done()
+					return ctx.Err()
+				}).MinTimes(0)
+			w.Init.MockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("foo"), gomock.Eq("baz")).
+				DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+					return nil, w.Init.Err
+				}).Times(3)
+		}).
+		Step(1, func(t *testing.T, w *Workspace) {
+			w.ER = NewEndpointsRegistry(context.Background(), w.Init.MockK8sClient, &w.Init.Options, w.Init.TickInterval)
+			t.Cleanup(w.ER.Stop)
+			_, err := w.ER.GetIPAddresses(context.Background(), "foo", "bar")
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			for i := 0; i < 3; i++ {
+				_, err := w.ER.GetIPAddresses(context.Background(), "foo", "baz")
+				if !assert.Error(t, err) {
+					t.FailNow()
+				}
+			}
+		}).
+		Step(2, func(t *testing.T, w *Workspace) {
+			w.ActOut.Health = w.ER.Health()
+			assert.Equal(t, w.ActOut.Health.FailingWatches >= 1, w.ActOut.Health.OldestSyncAge > 0)
+			assert.Equal(t, !w.ActOut.Health.APIServerReachable, w.ActOut.Health.UnreachableDuration > 0)
+			w.ActOut.Health.OldestSyncAge = 0
+			w.ActOut.Health.UnreachableDuration = 0
+			responseRecorder := httptest.NewRecorder()
+			NewHealthHandler(w.ER).ServeHTTP(responseRecorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			w.ActOut.StatusCode = responseRecorder.Code
+		}).
+		Step(3, func(t *testing.T, w *Workspace) {
+			assert.Equal(t, w.ExpOut, w.ActOut)
+		})
+	testcase.RunListParallel(t,
+		tc.Copy().
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				w.ExpOut.Health = Health{
+					Healthy:            true,
+					ActiveWatches:      1,
+					FailingWatches:     1,
+					APIServerReachable: true,
+				}
+				w.ExpOut.StatusCode = http.StatusOK
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.Options.HealthThresholds = HealthThresholds{MaxSyncAge: time.Nanosecond}
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				w.ExpOut.Health = Health{
+					ActiveWatches:      1,
+					FailingWatches:     1,
+					APIServerReachable: true,
+				}
+				w.ExpOut.StatusCode = http.StatusServiceUnavailable
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.Options.HealthThresholds = HealthThresholds{MaxUnreachableDuration: time.Nanosecond}
+				w.Init.Err = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				w.ExpOut.Health = Health{
+					ActiveWatches:  1,
+					FailingWatches: 1,
+				}
+				w.ExpOut.StatusCode = http.StatusServiceUnavailable
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.Options.HealthThresholds = HealthThresholds{MaxSyncAge: time.Nanosecond}
+				w.Init.TickInterval = 10 * time.Millisecond
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				for i := 0; i < 100; i++ {
+					if health := w.ER.Health(); health.ActiveWatches+health.FailingWatches == 0 &&
+						w.ER.History("foo", "bar") == nil && w.ER.History("foo", "baz") == nil {
+						break
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+				assert.Nil(t, w.ER.History("foo", "bar"))
+				assert.Nil(t, w.ER.History("foo", "baz"))
+				w.ExpOut.Health = Health{
+					Healthy:            true,
+					APIServerReachable: true,
+				}
+				w.ExpOut.StatusCode = http.StatusOK
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.WatchExpiry = true
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				for i := 0; i < 100 && atomic.LoadInt32(&w.WatchCount) < 2; i++ {
+					time.Sleep(MinWatchDuration / 10)
+				}
+				assert.Equal(t, int32(2), atomic.LoadInt32(&w.WatchCount))
+				w.ExpOut.Health = Health{
+					Healthy:            true,
+					ActiveWatches:      1,
+					FailingWatches:     1,
+					APIServerReachable: true,
+				}
+				w.ExpOut.StatusCode = http.StatusOK
+			}),
+	)
+}
@@ -0,0 +1,132 @@
+package kubetransport
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Hedging configures hedged requests.
+type Hedging struct {
+	// Delay is how long to wait for the response before sending a hedged request to
+	// another endpoint. Zero means the 95th percentile latency observed for the service.
+	Delay time.Duration
+}
+
+type hedgingKey struct{}
+
+// WithHedging returns a copy of the given context which enables hedged requests, i.e.
+// a second copy of the request is sent to another endpoint if no response arrives in
+// time, and the first response to arrive wins.
+// Hedged requests should only be enabled for idempotent requests and are limited by
+// the hedging budget of the transport wrapper.
+func WithHedging(ctx context.Context, hedging Hedging) context.Context {
+	return context.WithValue(ctx, hedgingKey{}, hedging)
+}
+
+func isReplayable(request *http.Request) bool {
+	return request.Body == nil || request.Body == http.NoBody || request.GetBody != nil
+}
+
+func (kt *kubeTransport) doHedgedRequest(request *http.Request, target *requestTarget, hedging Hedging) (*http.Response, error) {
+	delay := hedging.Delay
+	if delay == 0 {
+		var ok bool
+		if delay, ok = target.ServiceState.Latencies.Percentile(0.95); !ok {
+			return kt.doRequest(request, target, "")
+		}
+	}
+	ipAddress, endpointState, err := kt.pickEndpoint(request.Context(), target, "")
+	if err != nil {
+		return nil, err
+	}
+	results := make(chan hedgedResult, 2)
+	var cancels []context.CancelFunc
+	cancels = append(cancels, kt.sendHedgedRequest(request, target, ipAddress, endpointState, 0, results))
+	timer := time.NewTimer(delay)
+	select {
+	case result := <-results:
+		timer.Stop()
+		return result.Unwrap(cancels[0])
+	case <-timer.C:
+		if hedgedRequest, ok := kt.hedgeRequest(request, target); ok {
+			if ipAddress2, endpointState2, err := kt.pickEndpoint(request.Context(), target, ipAddress); err == nil {
+				cancels = append(cancels, kt.sendHedgedRequest(hedgedRequest, target, ipAddress2, endpointState2, 1, results))
+			}
+		}
+	}
+	var result hedgedResult
+	for n := len(cancels); n >= 1; n-- {
+		result = <-results
+		if result.Err == nil || n == 1 {
+			if n >= 2 {
+				for i, cancel := range cancels {
+					if i != result.I {
+						cancel()
+					}
+				}
+				go discardHedgedResults(results, n-1)
+			}
+			break
+		}
+		cancels[result.I]()
+	}
+	return result.Unwrap(cancels[result.I])
+}
+
+func (kt *kubeTransport) hedgeRequest(request *http.Request, target *requestTarget) (*http.Request, bool) {
+	if !target.ServiceState.HedgingBudget.Withdraw() {
+		return nil, false
+	}
+	hedgedRequest := request.Clone(request.Context())
+	if request.GetBody != nil {
+		body, err := request.GetBody()
+		if err != nil {
+			return nil, false
+		}
+		hedgedRequest.Body = body
+	}
+	return hedgedRequest, true
+}
+
+type hedgedResult struct {
+	I        int
+	Response *http.Response
+	Err      error
+}
+
+func (kt *kubeTransport) sendHedgedRequest(
+	request *http.Request,
+	target *requestTarget,
+	ipAddress string,
+	endpointState *endpointState,
+	i int,
+	results chan<- hedgedResult,
+) context.CancelFunc {
+	ctx, cancel := context.WithCancel(request.Context())
+	request = request.Clone(ctx)
+	go func() {
+		response, err := kt.sendRequest(request, target, ipAddress, endpointState)
+		results <- hedgedResult{i, response, err}
+	}()
+	return cancel
+}
+
+// Unwrap returns the response or the error, the context of the request is canceled once
+// the response body is closed.
+func (hr hedgedResult) Unwrap(cancel context.CancelFunc) (*http.Response, error) {
+	if hr.Err != nil {
+		cancel()
+		return nil, hr.Err
+	}
+	trackBody(hr.Response, cancel)
+	return hr.Response, nil
+}
+
+func discardHedgedResults(results <-chan hedgedResult, n int) {
+	for ; n >= 1; n-- {
+		if result := <-results; result.Err == nil && result.Response.Body != nil {
+			result.Response.Body.Close()
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package kubetransport
+
+import (
+	"sync"
+	"time"
+)
+
+// HistoryEntry is an entry of the history of the endpoints of a service.
+type HistoryEntry struct {
+	Time time.Time `json:"time"`
+
+	// EventType is GET if the endpoints are got, ERROR if the watch fails, or the type of
+	// the watch event, i.e. ADDED, MODIFIED or DELETED.
+	EventType string `json:"eventType"`
+
+	ResourceVersion    string   `json:"resourceVersion"`
+	AddedIPAddresses   []string `json:"addedIPAddresses,omitempty"`
+	RemovedIPAddresses []string `json:"removedIPAddresses,omitempty"`
+	Error              string   `json:"error,omitempty"`
+}
+
+// The event types of the history entries not from watch events.
+const (
+	historyEventGet   = "GET"
+	historyEventError = "ERROR"
+)
+
+// maxHistoryEntries is the number of the latest history entries kept for each service.
+const maxHistoryEntries = 16
+
+// eventHistory is a bounded history of the endpoints of a service. It outlives the
+// ip addresses sources, so that the history is kept across the restarts of watches.
+type eventHistory struct {
+	lock            sync.Mutex
+	entries         [maxHistoryEntries]HistoryEntry
+	entryCount      int
+	lastIPAddresses []string
+}
+
+func newEventHistory() *eventHistory { return new(eventHistory) }
+
+func (eh *eventHistory) RecordIPAddresses(eventType string, resourceVersion string, ipAddresses []string) {
+	eh.lock.Lock()
+	defer eh.lock.Unlock()
+	eh.add(HistoryEntry{
+		Time:               time.Now(),
+		EventType:          eventType,
+		ResourceVersion:    resourceVersion,
+		AddedIPAddresses:   diffIPAddresses(ipAddresses, eh.lastIPAddresses),
+		RemovedIPAddresses: diffIPAddresses(eh.lastIPAddresses, ipAddresses),
+	})
+	eh.lastIPAddresses = ipAddresses
+}
+
+func (eh *eventHistory) RecordError(resourceVersion string, err error) {
+	eh.lock.Lock()
+	defer eh.lock.Unlock()
+	eh.add(HistoryEntry{
+		Time:            time.Now(),
+		EventType:       historyEventError,
+		ResourceVersion: resourceVersion,
+		Error:           err.Error(),
+	})
+}
+
+func (eh *eventHistory) add(entry HistoryEntry) {
+	eh.entries[eh.entryCount%maxHistoryEntries] = entry
+	eh.entryCount++
+}
+
+// Entries returns the history entries from the oldest to the latest.
+func (eh *eventHistory) Entries() []HistoryEntry {
+	eh.lock.Lock()
+	defer eh.lock.Unlock()
+	n := eh.entryCount
+	if n > maxHistoryEntries {
+		n = maxHistoryEntries
+	}
+	entries := make([]HistoryEntry, n)
+	for i := range entries {
+		entries[i] = eh.entries[(eh.entryCount-n+i)%maxHistoryEntries]
+	}
+	return entries
+}
+
+func (er *endpointsRegistry) getEventHistory(endpointKey endpointKey) *eventHistory {
+	if value, ok := er.eventHistories.Load(endpointKey); ok {
+		return value.(*eventHistory)
+	}
+	value, _ := er.eventHistories.LoadOrStore(endpointKey, newEventHistory())
+	return value.(*eventHistory)
+}
+
+func (er *endpointsRegistry) History(namespace string, endpointsName string) []HistoryEntry {
+	if value, ok := er.eventHistories.Load(endpointKey{namespace, endpointsName}); ok {
+		return value.(*eventHistory).Entries()
+	}
+	return nil
+}
+
+// ResolutionError is the error returned when the endpoints of a service fail to resolve.
+// It carries the recent history of the endpoints for troubleshooting, and its message is
+// the one of the underlying error.
+type ResolutionError struct {
+	Namespace     string
+	EndpointsName string
+	History       []HistoryEntry
+
+	err error
+}
+
+var _ error = (*ResolutionError)(nil)
+
+// Error implements error.Error.
+func (re *ResolutionError) Error() string { return re.err.Error() }
+
+// Unwrap returns the underlying error.
+func (re *ResolutionError) Unwrap() error { return re.err }
@@ -3,6 +3,7 @@ package k8sclient
 var (
 	DoNew                  = doNew
 	DummyTransportReplacer = dummyTransportReplacer
+	DummyLogger            = dummyLogger{}
 )
 
 type Token = token
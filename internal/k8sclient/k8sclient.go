@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	neturl "net/url"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -23,10 +24,20 @@ type K8sClient interface {
 	Namespace() (namespace string)
 	GetEndpoints(ctx context.Context, namespace, endpointsName string) (endpoints *Endpoints, err error)
 	WatchEndpoints(ctx context.Context, namespace, endpointsName, resourceVersion string, callback WatchEndpointsCallback) (err error)
+	GetNode(ctx context.Context, nodeName string) (node *Node, err error)
+	GetService(ctx context.Context, namespace, serviceName string) (service *Service, err error)
+	WatchService(ctx context.Context, namespace, serviceName, resourceVersion string, callback WatchServiceCallback) (err error)
+	ListPods(ctx context.Context, namespace, labelSelector string) (podList *PodList, err error)
+	WatchPods(ctx context.Context, namespace, labelSelector, resourceVersion string, callback WatchPodsCallback) (err error)
+	ListEndpointSlices(ctx context.Context, namespace, serviceName string) (endpointSliceList *EndpointSliceList, err error)
+	WatchEndpointSlices(ctx context.Context, namespace, serviceName, resourceVersion string, callback WatchEndpointSlicesCallback) (err error)
 }
 
 type Metadata struct {
-	ResourceVersion string `json:"resourceVersion"`
+	Name            string            `json:"name"`
+	ResourceVersion string            `json:"resourceVersion"`
+	Labels          map[string]string `json:"labels"`
+	Annotations     map[string]string `json:"annotations"`
 }
 
 type Endpoints struct {
@@ -35,11 +46,103 @@ type Endpoints struct {
 }
 
 type EndpointSubset struct {
-	Addresses []EndpointAddress `json:"addresses"`
+	Addresses         []EndpointAddress `json:"addresses"`
+	NotReadyAddresses []EndpointAddress `json:"notReadyAddresses"`
 }
 
 type EndpointAddress struct {
-	IP string `json:"ip"`
+	IP        string           `json:"ip"`
+	Hostname  string           `json:"hostname"`
+	NodeName  string           `json:"nodeName"`
+	TargetRef *ObjectReference `json:"targetRef"`
+}
+
+type ObjectReference struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+type EndpointSliceList struct {
+	Metadata Metadata        `json:"metadata"`
+	Items    []EndpointSlice `json:"items"`
+}
+
+type EndpointSlice struct {
+	Metadata    Metadata    `json:"metadata"`
+	AddressType AddressType `json:"addressType"`
+	Endpoints   []Endpoint  `json:"endpoints"`
+}
+
+type AddressType string
+
+const (
+	AddressTypeIPv4 AddressType = "IPv4"
+	AddressTypeIPv6 AddressType = "IPv6"
+	AddressTypeFQDN AddressType = "FQDN"
+)
+
+type Endpoint struct {
+	Addresses  []string           `json:"addresses"`
+	Conditions EndpointConditions `json:"conditions"`
+	Hostname   string             `json:"hostname"`
+	NodeName   string             `json:"nodeName"`
+	Zone       string             `json:"zone"`
+	Hints      *EndpointHints     `json:"hints"`
+	TargetRef  *ObjectReference   `json:"targetRef"`
+}
+
+type EndpointHints struct {
+	ForZones []ForZone `json:"forZones"`
+}
+
+type ForZone struct {
+	Name string `json:"name"`
+}
+
+type EndpointConditions struct {
+	Ready       *bool `json:"ready"`
+	Serving     *bool `json:"serving"`
+	Terminating *bool `json:"terminating"`
+}
+
+type Node struct {
+	Metadata Metadata `json:"metadata"`
+}
+
+type PodList struct {
+	Metadata Metadata `json:"metadata"`
+	Items    []Pod    `json:"items"`
+}
+
+type Pod struct {
+	Metadata Metadata `json:"metadata"`
+}
+
+type Service struct {
+	Metadata Metadata    `json:"metadata"`
+	Spec     ServiceSpec `json:"spec"`
+}
+
+type ServiceSpec struct {
+	Type                  string                 `json:"type"`
+	Selector              map[string]string      `json:"selector"`
+	ExternalName          string                 `json:"externalName"`
+	SessionAffinity       string                 `json:"sessionAffinity"`
+	SessionAffinityConfig *SessionAffinityConfig `json:"sessionAffinityConfig"`
+}
+
+const (
+	ServiceTypeExternalName = "ExternalName"
+	SessionAffinityClientIP = "ClientIP"
+)
+
+type SessionAffinityConfig struct {
+	ClientIP *ClientIPConfig `json:"clientIP"`
+}
+
+type ClientIPConfig struct {
+	TimeoutSeconds *int `json:"timeoutSeconds"`
 }
 
 type EventType string
@@ -54,19 +157,33 @@ const (
 
 type WatchEndpointsCallback func(eventType EventType, endpoints *Endpoints) (ok bool)
 
-func New() (K8sClient, error) {
+type WatchServiceCallback func(eventType EventType, service *Service) (ok bool)
+
+type WatchEndpointSlicesCallback func(eventType EventType, endpointSlice *EndpointSlice) (ok bool)
+
+type WatchPodsCallback func(eventType EventType, pod *Pod) (ok bool)
+
+// Logger is a structured logger in the style of log/slog.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+}
+
+func New(logger Logger) (K8sClient, error) {
 	return doNew(
 		afero.NewOsFs(),
 		dummyTransportReplacer,
 		venv.OS(),
 		clock.New(),
+		logger,
 	)
 }
 
-func doNew(fs afero.Fs, transportReplacer transportReplacer, env venv.Env, clock clock.Clock) (*k8sClient, error) {
+func doNew(fs afero.Fs, transportReplacer transportReplacer, env venv.Env, clock clock.Clock, logger Logger) (*k8sClient, error) {
 	var kc k8sClient
 	kc.fs = fs
 	kc.clock = clock
+	kc.logger = logger
 	transport, err := makeTransport(fs)
 	if err != nil {
 		return nil, err
@@ -88,6 +205,11 @@ func doNew(fs afero.Fs, transportReplacer transportReplacer, env venv.Env, clock
 
 func dummyTransportReplacer(transport http.RoundTripper) http.RoundTripper { return transport }
 
+type dummyLogger struct{}
+
+func (dummyLogger) Debug(string, ...interface{}) {}
+func (dummyLogger) Warn(string, ...interface{})  {}
+
 const (
 	serviceHostEnvVarName = "KUBERNETES_SERVICE_HOST"
 	servicePortEnvVarName = "KUBERNETES_SERVICE_PORT"
@@ -101,6 +223,7 @@ const tokenRefreshInterval = 1 * time.Minute
 type k8sClient struct {
 	fs              afero.Fs
 	clock           clock.Clock
+	logger          Logger
 	client          http.Client
 	serviceHostPort string
 	namespace       string
@@ -170,7 +293,9 @@ func (kc *k8sClient) GetEndpoints(ctx context.Context, namespace, endpointsName
 
 func (kc *k8sClient) WatchEndpoints(ctx context.Context, namespace, endpointsName, resourceVersion string, callback WatchEndpointsCallback) error {
 	err := kc.doWatchEndpoints(ctx, namespace, endpointsName, resourceVersion, callback)
-	if status := (*status)(nil); errors.As(err, &status) && status.Code == http.StatusGone && resourceVersion != "" {
+	if errors.Is(err, ErrResourceVersionExpired) && resourceVersion != "" {
+		kc.logger.Debug("resource version expired, rewatching endpoints",
+			"namespace", namespace, "endpointsName", endpointsName, "resourceVersion", resourceVersion, "error", err)
 		err = kc.doWatchEndpoints(ctx, namespace, endpointsName, "", func(eventType EventType, endpoints *Endpoints) bool {
 			if endpoints != nil && endpoints.Metadata.ResourceVersion == resourceVersion {
 				return true
@@ -214,6 +339,184 @@ func (kc *k8sClient) doWatchEndpoints(ctx context.Context, namespace, endpointsN
 	}
 }
 
+func (kc *k8sClient) ListEndpointSlices(ctx context.Context, namespace, serviceName string) (*EndpointSliceList, error) {
+	url := kc.makeURL("/apis/discovery.k8s.io/v1/namespaces/%s/endpointslices?labelSelector=kubernetes.io%%2Fservice-name%%3D%s", namespace, serviceName)
+	response, err := kc.doGetRequest(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get %q; statusCode=%v", url, response.StatusCode)
+	}
+	var endpointSliceList EndpointSliceList
+	if err := json.NewDecoder(response.Body).Decode(&endpointSliceList); err != nil {
+		return nil, fmt.Errorf("decode endpoint slice list json: %w", err)
+	}
+	return &endpointSliceList, nil
+}
+
+func (kc *k8sClient) WatchEndpointSlices(ctx context.Context, namespace, serviceName, resourceVersion string, callback WatchEndpointSlicesCallback) error {
+	url := kc.makeURL("/apis/discovery.k8s.io/v1/namespaces/%s/endpointslices?labelSelector=kubernetes.io%%2Fservice-name%%3D%s&watch=1&resourceVersion=%s", namespace, serviceName, resourceVersion)
+	response, err := kc.doGetRequest(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("get %q; statusCode=%v", url, response.StatusCode)
+	}
+	decoder := json.NewDecoder(response.Body)
+	for {
+		var endpointSlice *EndpointSlice
+		event := event{
+			Object: &endpointSlice,
+		}
+		if err := decoder.Decode(&event); err != nil {
+			return fmt.Errorf("decode event json: %w", err)
+		}
+		if event.Type == eventError {
+			return fmt.Errorf("receive error event: %w", event.Object.(*status))
+		}
+		if !callback(event.Type, endpointSlice) {
+			return nil
+		}
+	}
+}
+
+func (kc *k8sClient) GetNode(ctx context.Context, nodeName string) (*Node, error) {
+	url := kc.makeURL("/api/v1/nodes/%s", nodeName)
+	response, err := kc.doGetRequest(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		if response.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get %q; statusCode=%v", url, response.StatusCode)
+	}
+	var node Node
+	if err := json.NewDecoder(response.Body).Decode(&node); err != nil {
+		return nil, fmt.Errorf("decode node json: %w", err)
+	}
+	return &node, nil
+}
+
+func (kc *k8sClient) ListPods(ctx context.Context, namespace, labelSelector string) (*PodList, error) {
+	url := kc.makeURL("/api/v1/namespaces/%s/pods?labelSelector=%s", namespace, neturl.QueryEscape(labelSelector))
+	response, err := kc.doGetRequest(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get %q; statusCode=%v", url, response.StatusCode)
+	}
+	var podList PodList
+	if err := json.NewDecoder(response.Body).Decode(&podList); err != nil {
+		return nil, fmt.Errorf("decode pod list json: %w", err)
+	}
+	return &podList, nil
+}
+
+func (kc *k8sClient) WatchPods(ctx context.Context, namespace, labelSelector, resourceVersion string, callback WatchPodsCallback) error {
+	url := kc.makeURL("/api/v1/namespaces/%s/pods?labelSelector=%s&watch=1&resourceVersion=%s", namespace, neturl.QueryEscape(labelSelector), resourceVersion)
+	response, err := kc.doGetRequest(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("get %q; statusCode=%v", url, response.StatusCode)
+	}
+	decoder := json.NewDecoder(response.Body)
+	for {
+		var pod *Pod
+		event := event{
+			Object: &pod,
+		}
+		if err := decoder.Decode(&event); err != nil {
+			return fmt.Errorf("decode event json: %w", err)
+		}
+		if event.Type == eventError {
+			return fmt.Errorf("receive error event: %w", event.Object.(*status))
+		}
+		if !callback(event.Type, pod) {
+			return nil
+		}
+	}
+}
+
+func (kc *k8sClient) GetService(ctx context.Context, namespace, serviceName string) (*Service, error) {
+	url := kc.makeURL("/api/v1/namespaces/%s/services/%s", namespace, serviceName)
+	response, err := kc.doGetRequest(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		if response.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get %q; statusCode=%v", url, response.StatusCode)
+	}
+	var service Service
+	if err := json.NewDecoder(response.Body).Decode(&service); err != nil {
+		return nil, fmt.Errorf("decode service json: %w", err)
+	}
+	return &service, nil
+}
+
+func (kc *k8sClient) WatchService(ctx context.Context, namespace, serviceName, resourceVersion string, callback WatchServiceCallback) error {
+	err := kc.doWatchService(ctx, namespace, serviceName, resourceVersion, callback)
+	if errors.Is(err, ErrResourceVersionExpired) && resourceVersion != "" {
+		kc.logger.Debug("resource version expired, rewatching service",
+			"namespace", namespace, "serviceName", serviceName, "resourceVersion", resourceVersion, "error", err)
+		err = kc.doWatchService(ctx, namespace, serviceName, "", func(eventType EventType, service *Service) bool {
+			if service != nil && service.Metadata.ResourceVersion == resourceVersion {
+				return true
+			}
+			return callback(eventType, service)
+		})
+	}
+	return err
+}
+
+func (kc *k8sClient) doWatchService(ctx context.Context, namespace, serviceName, resourceVersion string, callback WatchServiceCallback) error {
+	var url string
+	if resourceVersion == "" {
+		url = kc.makeURL("/api/v1/watch/namespaces/%s/services/%s", namespace, serviceName)
+	} else {
+		url = kc.makeURL("/api/v1/watch/namespaces/%s/services/%s?resourceVersion=%s", namespace, serviceName, resourceVersion)
+	}
+	response, err := kc.doGetRequest(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("get %q; statusCode=%v", url, response.StatusCode)
+	}
+	decoder := json.NewDecoder(response.Body)
+	for {
+		var service *Service
+		event := event{
+			Object: &service,
+		}
+		if err := decoder.Decode(&event); err != nil {
+			return fmt.Errorf("decode event json: %w", err)
+		}
+		if event.Type == eventError {
+			return fmt.Errorf("receive error event: %w", event.Object.(*status))
+		}
+		if !callback(event.Type, service) {
+			return nil
+		}
+	}
+}
+
 func (kc *k8sClient) makeURL(urlPathTemplate string, args ...interface{}) string {
 	return fmt.Sprintf("https://"+kc.serviceHostPort+urlPathTemplate, args...)
 }
@@ -233,6 +536,7 @@ func (kc *k8sClient) doGetRequest(ctx context.Context, url string) (*http.Respon
 		return nil, fmt.Errorf("get %q: %w", url, err)
 	}
 	if response.StatusCode == http.StatusUnauthorized {
+		kc.logger.Warn("unauthorized, resetting token", "url", url)
 		kc.token.Reset()
 	}
 	return response, nil
@@ -307,6 +611,14 @@ var _ error = (*status)(nil)
 
 func (s *status) Error() string { return s.Message }
 
+func (s *status) Is(err error) bool {
+	return err == ErrResourceVersionExpired && s.Code == http.StatusGone
+}
+
+// ErrResourceVersionExpired is matched by the error returned by a watch whose resource
+// version is too old (410 Gone).
+var ErrResourceVersionExpired = errors.New("k8sclient: resource version expired")
+
 func (e *event) UnmarshalJSON(data []byte) error {
 	rawEvent := struct {
 		Type   EventType       `json:"type"`
@@ -26,7 +26,7 @@ func TestK8sClientWithIntegration(t *testing.T) {
 }
 
 func testK8sClientWithIntegration_GetEndpoints(t *testing.T, te *testingEnvironment) {
-	kc, err := DoNew(te.Fs, DummyTransportReplacer, te.Env, te.Clock)
+	kc, err := DoNew(te.Fs, DummyTransportReplacer, te.Env, te.Clock, DummyLogger)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -106,7 +106,7 @@ func testK8sClientWithIntegration_GetEndpoints(t *testing.T, te *testingEnvironm
 }
 
 func testK8sClientWithIntegration_WatchEndpoints(t *testing.T, te *testingEnvironment) {
-	kc, err := DoNew(te.Fs, DummyTransportReplacer, te.Env, te.Clock)
+	kc, err := DoNew(te.Fs, DummyTransportReplacer, te.Env, te.Clock, DummyLogger)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -39,7 +39,7 @@ func TestDoNew(t *testing.T) {
 			w.In.MockClock.Set(time.Now())
 		}).
 		Step(1, func(t *testing.T, w *Workspace) {
-			w.KC, w.ActOut.Err = DoNew(w.In.Fs, DummyTransportReplacer, w.In.Env, w.In.MockClock)
+			w.KC, w.ActOut.Err = DoNew(w.In.Fs, DummyTransportReplacer, w.In.Env, w.In.MockClock, DummyLogger)
 			if w.ActOut.Err != nil {
 				w.ActOut.ErrStr = w.ActOut.Err.Error()
 			}
@@ -292,7 +292,7 @@ lO/HJTzVSS8CIQCySRrL0DQOyd2PYzqPvUq7XHuiIfRqLtLOP4+j7fDGDQ==
 		}).
 		Step(1, func(t *testing.T, w *Workspace) {
 			var err error
-			w.KC, err = DoNew(w.Init.Fs, func(http.RoundTripper) http.RoundTripper { return w.Init.MockTransport }, w.Init.Env, w.Init.MockClock)
+			w.KC, err = DoNew(w.Init.Fs, func(http.RoundTripper) http.RoundTripper { return w.Init.MockTransport }, w.Init.Env, w.Init.MockClock, DummyLogger)
 			if !assert.NoError(t, err) {
 				t.FailNow()
 			}
@@ -422,6 +422,1036 @@ lO/HJTzVSS8CIQCySRrL0DQOyd2PYzqPvUq7XHuiIfRqLtLOP4+j7fDGDQ==
 	)
 }
 
+func TestK8sClient_GetNode(t *testing.T) {
+	type Workspace struct {
+		Init struct {
+			Fs            afero.Fs
+			MockTransport *httpmock.MockTransport
+			Env           venv.Env
+			MockClock     *clock.Mock
+		}
+		In struct {
+			Ctx      context.Context
+			NodeName string
+		}
+		ExpOut, ActOut struct {
+			Node   *Node
+			Err    error
+			ErrStr string
+		}
+		KC K8sClient
+	}
+	tc := testcase.New().
+		Step(0, func(t *testing.T, w *Workspace) {
+			w.Init.Fs = afero.NewMemMapFs()
+			w.Init.MockTransport = httpmock.NewMockTransport()
+			w.Init.Env = venv.Mock()
+			w.Init.MockClock = clock.NewMock()
+			w.Init.MockClock.Set(time.Now())
+			w.In.Ctx = context.Background()
+			err := afero.WriteFile(
+				w.Init.Fs,
+				"/var/run/secrets/kubernetes.io/serviceaccount/ca.crt",
+				[]byte(`-----BEGIN CERTIFICATE-----
+MIIBdzCCAR2gAwIBAgIBADAKBggqhkjOPQQDAjAjMSEwHwYDVQQDDBhrM3Mtc2Vy
+dmVyLWNhQDE2MjM1MDQ5MDYwHhcNMjEwNjEyMTMzNTA2WhcNMzEwNjEwMTMzNTA2
+WjAjMSEwHwYDVQQDDBhrM3Mtc2VydmVyLWNhQDE2MjM1MDQ5MDYwWTATBgcqhkjO
+PQIBBggqhkjOPQMBBwNCAAQ3qTr0SbaK0a7zf8LqavDZsV0dwTvXTnmkDa4DJ7XZ
+/zU1E1rBuCeJ4hmqnLB97k5ePamOrFEcQljOI27+2/2Qo0IwQDAOBgNVHQ8BAf8E
+BAMCAqQwDwYDVR0TAQH/BAUwAwEB/zAdBgNVHQ4EFgQUmAS4j2mFkRsIbhk2FlrO
++9eFeKswCgYIKoZIzj0EAwIDSAAwRQIgH6sg05GpW0gOrVySsQgO5LD3ythEfJte
+lO/HJTzVSS8CIQCySRrL0DQOyd2PYzqPvUq7XHuiIfRqLtLOP4+j7fDGDQ==
+-----END CERTIFICATE-----
+`),
+				644,
+			)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			w.Init.Env.Setenv("KUBERNETES_SERVICE_HOST", "1.2.3.4")
+			w.Init.Env.Setenv("KUBERNETES_SERVICE_PORT", "6443")
+			err = afero.WriteFile(
+				w.Init.Fs,
+				"/var/run/secrets/kubernetes.io/serviceaccount/namespace",
+				[]byte("default"),
+				644,
+			)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			err = afero.WriteFile(
+				w.Init.Fs,
+				"/var/run/secrets/kubernetes.io/serviceaccount/token",
+				[]byte("admin"),
+				644,
+			)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+		}).
+		Step(1, func(t *testing.T, w *Workspace) {
+			var err error
+			w.KC, err = DoNew(w.Init.Fs, func(http.RoundTripper) http.RoundTripper { return w.Init.MockTransport }, w.Init.Env, w.Init.MockClock, DummyLogger)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+		}).
+		Step(2, func(t *testing.T, w *Workspace) {
+			w.ActOut.Node, w.ActOut.Err = w.KC.GetNode(w.In.Ctx, w.In.NodeName)
+			if w.ActOut.Err != nil {
+				w.ActOut.ErrStr = w.ActOut.Err.Error()
+			}
+		}).
+		Step(3, func(t *testing.T, w *Workspace) {
+			if w.ExpOut.Err == nil || errors.Is(w.ActOut.Err, w.ExpOut.Err) {
+				w.ExpOut.Err = w.ActOut.Err
+			}
+			assert.Equal(t, w.ExpOut, w.ActOut)
+		})
+	testcase.RunListParallel(t,
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.MockTransport.RegisterResponder(
+					"GET",
+					"https://1.2.3.4:6443/api/v1/nodes/foo",
+					httpmock.NewBytesResponder(404, nil),
+				)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				w.In.NodeName = "foo"
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.MockTransport.RegisterResponder(
+					"GET",
+					"https://1.2.3.4:6443/api/v1/nodes/foo",
+					httpmock.NewStringResponder(200, `{
+	"metadata": {
+		"resourceVersion": "8910",
+		"labels": {
+			"topology.kubernetes.io/zone": "us-east-1a"
+		}
+	}
+}
+`),
+				)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				w.In.NodeName = "foo"
+				w.ExpOut.Node = &Node{
+					Metadata: Metadata{
+						ResourceVersion: "8910",
+						Labels: map[string]string{
+							"topology.kubernetes.io/zone": "us-east-1a",
+						},
+					},
+				}
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.MockTransport.RegisterResponder(
+					"GET",
+					"https://1.2.3.4:6443/api/v1/nodes/foo",
+					httpmock.NewBytesResponder(403, nil),
+				)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				w.In.NodeName = "foo"
+				w.ExpOut.ErrStr = "get \"https://1.2.3.4:6443/api/v1/nodes/foo\"; statusCode=403"
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.MockTransport.RegisterResponder(
+					"GET",
+					"https://1.2.3.4:6443/api/v1/nodes/foo",
+					httpmock.NewStringResponder(200, "[]"),
+				)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				w.In.NodeName = "foo"
+				w.ExpOut.ErrStr = "decode node json: json: cannot unmarshal array into Go value of type k8sclient.Node"
+			}),
+	)
+}
+
+func TestK8sClient_ListPods(t *testing.T) {
+	type Workspace struct {
+		Init struct {
+			Fs            afero.Fs
+			MockTransport *httpmock.MockTransport
+			Env           venv.Env
+			MockClock     *clock.Mock
+		}
+		In struct {
+			Ctx           context.Context
+			Namespace     string
+			LabelSelector string
+		}
+		ExpOut, ActOut struct {
+			PodList *PodList
+			Err     error
+			ErrStr  string
+		}
+		KC K8sClient
+	}
+	tc := testcase.New().
+		Step(0, func(t *testing.T, w *Workspace) {
+			w.Init.Fs = afero.NewMemMapFs()
+			w.Init.MockTransport = httpmock.NewMockTransport()
+			w.Init.Env = venv.Mock()
+			w.Init.MockClock = clock.NewMock()
+			w.Init.MockClock.Set(time.Now())
+			w.In.Ctx = context.Background()
+			err := afero.WriteFile(
+				w.Init.Fs,
+				"/var/run/secrets/kubernetes.io/serviceaccount/ca.crt",
+				[]byte(`-----BEGIN CERTIFICATE-----
+MIIBdzCCAR2gAwIBAgIBADAKBggqhkjOPQQDAjAjMSEwHwYDVQQDDBhrM3Mtc2Vy
+dmVyLWNhQDE2MjM1MDQ5MDYwHhcNMjEwNjEyMTMzNTA2WhcNMzEwNjEwMTMzNTA2
+WjAjMSEwHwYDVQQDDBhrM3Mtc2VydmVyLWNhQDE2MjM1MDQ5MDYwWTATBgcqhkjO
+PQIBBggqhkjOPQMBBwNCAAQ3qTr0SbaK0a7zf8LqavDZsV0dwTvXTnmkDa4DJ7XZ
+/zU1E1rBuCeJ4hmqnLB97k5ePamOrFEcQljOI27+2/2Qo0IwQDAOBgNVHQ8BAf8E
+BAMCAqQwDwYDVR0TAQH/BAUwAwEB/zAdBgNVHQ4EFgQUmAS4j2mFkRsIbhk2FlrO
++9eFeKswCgYIKoZIzj0EAwIDSAAwRQIgH6sg05GpW0gOrVySsQgO5LD3ythEfJte
+lO/HJTzVSS8CIQCySRrL0DQOyd2PYzqPvUq7XHuiIfRqLtLOP4+j7fDGDQ==
+-----END CERTIFICATE-----
+`),
+				644,
+			)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			w.Init.Env.Setenv("KUBERNETES_SERVICE_HOST", "1.2.3.4")
+			w.Init.Env.Setenv("KUBERNETES_SERVICE_PORT", "6443")
+			err = afero.WriteFile(
+				w.Init.Fs,
+				"/var/run/secrets/kubernetes.io/serviceaccount/namespace",
+				[]byte("default"),
+				644,
+			)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			err = afero.WriteFile(
+				w.Init.Fs,
+				"/var/run/secrets/kubernetes.io/serviceaccount/token",
+				[]byte("admin"),
+				644,
+			)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+		}).
+		Step(1, func(t *testing.T, w *Workspace) {
+			var err error
+			w.KC, err = DoNew(w.Init.Fs, func(http.RoundTripper) http.RoundTripper { return w.Init.MockTransport }, w.Init.Env, w.Init.MockClock, DummyLogger)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+		}).
+		Step(2, func(t *testing.T, w *Workspace) {
+			w.ActOut.PodList, w.ActOut.Err = w.KC.ListPods(w.In.Ctx, w.In.Namespace, w.In.LabelSelector)
+			if w.ActOut.Err != nil {
+				w.ActOut.ErrStr = w.ActOut.Err.Error()
+			}
+		}).
+		Step(3, func(t *testing.T, w *Workspace) {
+			if w.ExpOut.Err == nil || errors.Is(w.ActOut.Err, w.ExpOut.Err) {
+				w.ExpOut.Err = w.ActOut.Err
+			}
+			assert.Equal(t, w.ExpOut, w.ActOut)
+		})
+	testcase.RunListParallel(t,
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.MockTransport.RegisterResponder(
+					"GET",
+					"https://1.2.3.4:6443/api/v1/namespaces/foo/pods?labelSelector=app%3Dbar%2Ctier%3Dweb",
+					httpmock.NewStringResponder(200, `{
+	"metadata": {
+		"resourceVersion": "8910"
+	},
+	"items": [
+		{
+			"metadata": {
+				"name": "bar-1",
+				"resourceVersion": "8900",
+				"labels": {
+					"app": "bar",
+					"tier": "web",
+					"version": "v2"
+				}
+			}
+		}
+	]
+}
+`),
+				)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				w.In.Namespace = "foo"
+				w.In.LabelSelector = "app=bar,tier=web"
+				w.ExpOut.PodList = &PodList{
+					Metadata: Metadata{
+						ResourceVersion: "8910",
+					},
+					Items: []Pod{
+						{
+							Metadata: Metadata{
+								Name:            "bar-1",
+								ResourceVersion: "8900",
+								Labels: map[string]string{
+									"app":     "bar",
+									"tier":    "web",
+									"version": "v2",
+								},
+							},
+						},
+					},
+				}
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.MockTransport.RegisterResponder(
+					"GET",
+					"https://1.2.3.4:6443/api/v1/namespaces/foo/pods?labelSelector=app%3Dbar",
+					httpmock.NewBytesResponder(403, nil),
+				)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				w.In.Namespace = "foo"
+				w.In.LabelSelector = "app=bar"
+				w.ExpOut.ErrStr = "get \"https://1.2.3.4:6443/api/v1/namespaces/foo/pods?labelSelector=app%3Dbar\"; statusCode=403"
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.MockTransport.RegisterResponder(
+					"GET",
+					"https://1.2.3.4:6443/api/v1/namespaces/foo/pods?labelSelector=app%3Dbar",
+					httpmock.NewStringResponder(200, "[]"),
+				)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				w.In.Namespace = "foo"
+				w.In.LabelSelector = "app=bar"
+				w.ExpOut.ErrStr = "decode pod list json: json: cannot unmarshal array into Go value of type k8sclient.PodList"
+			}),
+	)
+}
+
+func TestK8sClient_WatchPods(t *testing.T) {
+	type CallbackArgs struct {
+		EventType EventType
+		Pod       *Pod
+	}
+	type Workspace struct {
+		Init struct {
+			Fs            afero.Fs
+			MockTransport *httpmock.MockTransport
+			Env           venv.Env
+			MockClock     *clock.Mock
+		}
+		In struct {
+			Ctx             context.Context
+			Namespace       string
+			LabelSelector   string
+			ResourceVersion string
+		}
+		ExpOut, ActOut struct {
+			CAs    []CallbackArgs
+			Err    error
+			ErrStr string
+		}
+		KC K8sClient
+	}
+	tc := testcase.New().
+		Step(0, func(t *testing.T, w *Workspace) {
+			w.Init.Fs = afero.NewMemMapFs()
+			w.Init.MockTransport = httpmock.NewMockTransport()
+			w.Init.Env = venv.Mock()
+			w.Init.MockClock = clock.NewMock()
+			w.Init.MockClock.Set(time.Now())
+			w.In.Ctx = context.Background()
+			err := afero.WriteFile(
+				w.Init.Fs,
+				"/var/run/secrets/kubernetes.io/serviceaccount/ca.crt",
+				[]byte(`-----BEGIN CERTIFICATE-----
+MIIBdzCCAR2gAwIBAgIBADAKBggqhkjOPQQDAjAjMSEwHwYDVQQDDBhrM3Mtc2Vy
+dmVyLWNhQDE2MjM1MDQ5MDYwHhcNMjEwNjEyMTMzNTA2WhcNMzEwNjEwMTMzNTA2
+WjAjMSEwHwYDVQQDDBhrM3Mtc2VydmVyLWNhQDE2MjM1MDQ5MDYwWTATBgcqhkjO
+PQIBBggqhkjOPQMBBwNCAAQ3qTr0SbaK0a7zf8LqavDZsV0dwTvXTnmkDa4DJ7XZ
+/zU1E1rBuCeJ4hmqnLB97k5ePamOrFEcQljOI27+2/2Qo0IwQDAOBgNVHQ8BAf8E
+BAMCAqQwDwYDVR0TAQH/BAUwAwEB/zAdBgNVHQ4EFgQUmAS4j2mFkRsIbhk2FlrO
++9eFeKswCgYIKoZIzj0EAwIDSAAwRQIgH6sg05GpW0gOrVySsQgO5LD3ythEfJte
+lO/HJTzVSS8CIQCySRrL0DQOyd2PYzqPvUq7XHuiIfRqLtLOP4+j7fDGDQ==
+-----END CERTIFICATE-----
+`),
+				644,
+			)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			w.Init.Env.Setenv("KUBERNETES_SERVICE_HOST", "1.2.3.4")
+			w.Init.Env.Setenv("KUBERNETES_SERVICE_PORT", "6443")
+			err = afero.WriteFile(
+				w.Init.Fs,
+				"/var/run/secrets/kubernetes.io/serviceaccount/namespace",
+				[]byte("default"),
+				644,
+			)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			err = afero.WriteFile(
+				w.Init.Fs,
+				"/var/run/secrets/kubernetes.io/serviceaccount/token",
+				[]byte("admin"),
+				644,
+			)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+		}).
+		Step(1, func(t *testing.T, w *Workspace) {
+			var err error
+			w.KC, err = DoNew(w.Init.Fs, func(http.RoundTripper) http.RoundTripper { return w.Init.MockTransport }, w.Init.Env, w.Init.MockClock, DummyLogger)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+		}).
+		Step(2, func(t *testing.T, w *Workspace) {
+			w.ActOut.Err = w.KC.WatchPods(w.In.Ctx, w.In.Namespace, w.In.LabelSelector, w.In.ResourceVersion, func(eventType EventType, pod *Pod) bool {
+				w.ActOut.CAs = append(w.ActOut.CAs, CallbackArgs{eventType, pod})
+				return true
+			})
+			if w.ActOut.Err != nil {
+				w.ActOut.ErrStr = w.ActOut.Err.Error()
+			}
+		}).
+		Step(3, func(t *testing.T, w *Workspace) {
+			if w.ExpOut.Err == nil || errors.Is(w.ActOut.Err, w.ExpOut.Err) {
+				w.ExpOut.Err = w.ActOut.Err
+			}
+			assert.Equal(t, w.ExpOut, w.ActOut)
+		})
+	testcase.RunListParallel(t,
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.MockTransport.RegisterResponder(
+					"GET",
+					"https://1.2.3.4:6443/api/v1/namespaces/foo/pods?labelSelector=app%3Dbar&watch=1&resourceVersion=8910",
+					httpmock.NewStringResponder(200, `{
+	"type": "MODIFIED",
+	"object": {
+		"metadata": {
+			"name": "bar-1",
+			"resourceVersion": "8920",
+			"labels": {"app": "bar", "version": "v3"}
+		}
+	}
+}
+{
+	"type": "DELETED",
+	"object": {
+		"metadata": {
+			"name": "bar-1",
+			"resourceVersion": "8930"
+		}
+	}
+}
+`),
+				)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				w.In.Namespace = "foo"
+				w.In.LabelSelector = "app=bar"
+				w.In.ResourceVersion = "8910"
+				w.ExpOut.CAs = []CallbackArgs{
+					{
+						EventType: EventModified,
+						Pod: &Pod{
+							Metadata: Metadata{
+								Name:            "bar-1",
+								ResourceVersion: "8920",
+								Labels:          map[string]string{"app": "bar", "version": "v3"},
+							},
+						},
+					},
+					{
+						EventType: EventDeleted,
+						Pod: &Pod{
+							Metadata: Metadata{
+								Name:            "bar-1",
+								ResourceVersion: "8930",
+							},
+						},
+					},
+				}
+				w.ExpOut.Err = io.EOF
+				w.ExpOut.ErrStr = "decode event json: EOF"
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.MockTransport.RegisterResponder(
+					"GET",
+					"https://1.2.3.4:6443/api/v1/namespaces/foo/pods?labelSelector=app%3Dbar&watch=1&resourceVersion=8910",
+					httpmock.NewStringResponder(200, `{
+	"type": "ERROR",
+	"object": {
+		"code": 410,
+		"message": "gone"
+	}
+}
+`),
+				)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				w.In.Namespace = "foo"
+				w.In.LabelSelector = "app=bar"
+				w.In.ResourceVersion = "8910"
+				w.ExpOut.Err = ErrResourceVersionExpired
+				w.ExpOut.ErrStr = "receive error event: gone"
+			}),
+	)
+}
+
+func TestK8sClient_GetService(t *testing.T) {
+	type Workspace struct {
+		Init struct {
+			Fs            afero.Fs
+			MockTransport *httpmock.MockTransport
+			Env           venv.Env
+			MockClock     *clock.Mock
+		}
+		In struct {
+			Ctx         context.Context
+			Namespace   string
+			ServiceName string
+		}
+		ExpOut, ActOut struct {
+			Service *Service
+			Err     error
+			ErrStr  string
+		}
+		KC K8sClient
+	}
+	tc := testcase.New().
+		Step(0, func(t *testing.T, w *Workspace) {
+			w.Init.Fs = afero.NewMemMapFs()
+			w.Init.MockTransport = httpmock.NewMockTransport()
+			w.Init.Env = venv.Mock()
+			w.Init.MockClock = clock.NewMock()
+			w.Init.MockClock.Set(time.Now())
+			w.In.Ctx = context.Background()
+			err := afero.WriteFile(
+				w.Init.Fs,
+				"/var/run/secrets/kubernetes.io/serviceaccount/ca.crt",
+				[]byte(`-----BEGIN CERTIFICATE-----
+MIIBdzCCAR2gAwIBAgIBADAKBggqhkjOPQQDAjAjMSEwHwYDVQQDDBhrM3Mtc2Vy
+dmVyLWNhQDE2MjM1MDQ5MDYwHhcNMjEwNjEyMTMzNTA2WhcNMzEwNjEwMTMzNTA2
+WjAjMSEwHwYDVQQDDBhrM3Mtc2VydmVyLWNhQDE2MjM1MDQ5MDYwWTATBgcqhkjO
+PQIBBggqhkjOPQMBBwNCAAQ3qTr0SbaK0a7zf8LqavDZsV0dwTvXTnmkDa4DJ7XZ
+/zU1E1rBuCeJ4hmqnLB97k5ePamOrFEcQljOI27+2/2Qo0IwQDAOBgNVHQ8BAf8E
+BAMCAqQwDwYDVR0TAQH/BAUwAwEB/zAdBgNVHQ4EFgQUmAS4j2mFkRsIbhk2FlrO
++9eFeKswCgYIKoZIzj0EAwIDSAAwRQIgH6sg05GpW0gOrVySsQgO5LD3ythEfJte
+lO/HJTzVSS8CIQCySRrL0DQOyd2PYzqPvUq7XHuiIfRqLtLOP4+j7fDGDQ==
+-----END CERTIFICATE-----
+`),
+				644,
+			)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			w.Init.Env.Setenv("KUBERNETES_SERVICE_HOST", "1.2.3.4")
+			w.Init.Env.Setenv("KUBERNETES_SERVICE_PORT", "6443")
+			err = afero.WriteFile(
+				w.Init.Fs,
+				"/var/run/secrets/kubernetes.io/serviceaccount/namespace",
+				[]byte("default"),
+				644,
+			)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			err = afero.WriteFile(
+				w.Init.Fs,
+				"/var/run/secrets/kubernetes.io/serviceaccount/token",
+				[]byte("admin"),
+				644,
+			)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+		}).
+		Step(1, func(t *testing.T, w *Workspace) {
+			var err error
+			w.KC, err = DoNew(w.Init.Fs, func(http.RoundTripper) http.RoundTripper { return w.Init.MockTransport }, w.Init.Env, w.Init.MockClock, DummyLogger)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+		}).
+		Step(2, func(t *testing.T, w *Workspace) {
+			w.ActOut.Service, w.ActOut.Err = w.KC.GetService(w.In.Ctx, w.In.Namespace, w.In.ServiceName)
+			if w.ActOut.Err != nil {
+				w.ActOut.ErrStr = w.ActOut.Err.Error()
+			}
+		}).
+		Step(3, func(t *testing.T, w *Workspace) {
+			if w.ExpOut.Err == nil || errors.Is(w.ActOut.Err, w.ExpOut.Err) {
+				w.ExpOut.Err = w.ActOut.Err
+			}
+			assert.Equal(t, w.ExpOut, w.ActOut)
+		})
+	testcase.RunListParallel(t,
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.MockTransport.RegisterResponder(
+					"GET",
+					"https://1.2.3.4:6443/api/v1/namespaces/foo/services/bar",
+					httpmock.NewBytesResponder(404, nil),
+				)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				w.In.Namespace = "foo"
+				w.In.ServiceName = "bar"
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.MockTransport.RegisterResponder(
+					"GET",
+					"https://1.2.3.4:6443/api/v1/namespaces/foo/services/bar",
+					httpmock.NewStringResponder(200, `{
+	"metadata": {
+		"resourceVersion": "8910"
+	},
+	"spec": {
+		"type": "ClusterIP",
+		"sessionAffinity": "ClientIP",
+		"sessionAffinityConfig": {
+			"clientIP": {
+				"timeoutSeconds": 600
+			}
+		}
+	}
+}
+`),
+				)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				w.In.Namespace = "foo"
+				w.In.ServiceName = "bar"
+				timeoutSeconds := 600
+				w.ExpOut.Service = &Service{
+					Metadata: Metadata{
+						ResourceVersion: "8910",
+					},
+					Spec: ServiceSpec{
+						Type:            "ClusterIP",
+						SessionAffinity: SessionAffinityClientIP,
+						SessionAffinityConfig: &SessionAffinityConfig{
+							ClientIP: &ClientIPConfig{TimeoutSeconds: &timeoutSeconds},
+						},
+					},
+				}
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.MockTransport.RegisterResponder(
+					"GET",
+					"https://1.2.3.4:6443/api/v1/namespaces/foo/services/bar",
+					httpmock.NewBytesResponder(403, nil),
+				)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				w.In.Namespace = "foo"
+				w.In.ServiceName = "bar"
+				w.ExpOut.ErrStr = "get \"https://1.2.3.4:6443/api/v1/namespaces/foo/services/bar\"; statusCode=403"
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.MockTransport.RegisterResponder(
+					"GET",
+					"https://1.2.3.4:6443/api/v1/namespaces/foo/services/bar",
+					httpmock.NewStringResponder(200, "[]"),
+				)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				w.In.Namespace = "foo"
+				w.In.ServiceName = "bar"
+				w.ExpOut.ErrStr = "decode service json: json: cannot unmarshal array into Go value of type k8sclient.Service"
+			}),
+	)
+}
+
+func TestK8sClient_ListEndpointSlices(t *testing.T) {
+	type Workspace struct {
+		Init struct {
+			Fs            afero.Fs
+			MockTransport *httpmock.MockTransport
+			Env           venv.Env
+			MockClock     *clock.Mock
+		}
+		In struct {
+			Ctx         context.Context
+			Namespace   string
+			ServiceName string
+		}
+		ExpOut, ActOut struct {
+			EndpointSliceList *EndpointSliceList
+			Err               error
+			ErrStr            string
+		}
+		KC K8sClient
+	}
+	tc := testcase.New().
+		Step(0, func(t *testing.T, w *Workspace) {
+			w.Init.Fs = afero.NewMemMapFs()
+			w.Init.MockTransport = httpmock.NewMockTransport()
+			w.Init.Env = venv.Mock()
+			w.Init.MockClock = clock.NewMock()
+			w.Init.MockClock.Set(time.Now())
+			w.In.Ctx = context.Background()
+			err := afero.WriteFile(
+				w.Init.Fs,
+				"/var/run/secrets/kubernetes.io/serviceaccount/ca.crt",
+				[]byte(`-----BEGIN CERTIFICATE-----
+MIIBdzCCAR2gAwIBAgIBADAKBggqhkjOPQQDAjAjMSEwHwYDVQQDDBhrM3Mtc2Vy
+dmVyLWNhQDE2MjM1MDQ5MDYwHhcNMjEwNjEyMTMzNTA2WhcNMzEwNjEwMTMzNTA2
+WjAjMSEwHwYDVQQDDBhrM3Mtc2VydmVyLWNhQDE2MjM1MDQ5MDYwWTATBgcqhkjO
+PQIBBggqhkjOPQMBBwNCAAQ3qTr0SbaK0a7zf8LqavDZsV0dwTvXTnmkDa4DJ7XZ
+/zU1E1rBuCeJ4hmqnLB97k5ePamOrFEcQljOI27+2/2Qo0IwQDAOBgNVHQ8BAf8E
+BAMCAqQwDwYDVR0TAQH/BAUwAwEB/zAdBgNVHQ4EFgQUmAS4j2mFkRsIbhk2FlrO
++9eFeKswCgYIKoZIzj0EAwIDSAAwRQIgH6sg05GpW0gOrVySsQgO5LD3ythEfJte
+lO/HJTzVSS8CIQCySRrL0DQOyd2PYzqPvUq7XHuiIfRqLtLOP4+j7fDGDQ==
+-----END CERTIFICATE-----
+`),
+				644,
+			)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			w.Init.Env.Setenv("KUBERNETES_SERVICE_HOST", "1.2.3.4")
+			w.Init.Env.Setenv("KUBERNETES_SERVICE_PORT", "6443")
+			err = afero.WriteFile(
+				w.Init.Fs,
+				"/var/run/secrets/kubernetes.io/serviceaccount/namespace",
+				[]byte("default"),
+				644,
+			)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			err = afero.WriteFile(
+				w.Init.Fs,
+				"/var/run/secrets/kubernetes.io/serviceaccount/token",
+				[]byte("admin"),
+				644,
+			)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+		}).
+		Step(1, func(t *testing.T, w *Workspace) {
+			var err error
+			w.KC, err = DoNew(w.Init.Fs, func(http.RoundTripper) http.RoundTripper { return w.Init.MockTransport }, w.Init.Env, w.Init.MockClock, DummyLogger)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+		}).
+		Step(2, func(t *testing.T, w *Workspace) {
+			w.ActOut.EndpointSliceList, w.ActOut.Err = w.KC.ListEndpointSlices(w.In.Ctx, w.In.Namespace, w.In.ServiceName)
+			if w.ActOut.Err != nil {
+				w.ActOut.ErrStr = w.ActOut.Err.Error()
+			}
+		}).
+		Step(3, func(t *testing.T, w *Workspace) {
+			if w.ExpOut.Err == nil || errors.Is(w.ActOut.Err, w.ExpOut.Err) {
+				w.ExpOut.Err = w.ActOut.Err
+			}
+			assert.Equal(t, w.ExpOut, w.ActOut)
+		})
+	testcase.RunListParallel(t,
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.MockTransport.RegisterResponder(
+					"GET",
+					"https://1.2.3.4:6443/apis/discovery.k8s.io/v1/namespaces/foo/endpointslices?labelSelector=kubernetes.io%2Fservice-name%3Dbar",
+					httpmock.NewStringResponder(200, `{
+	"metadata": {
+		"resourceVersion": "8910"
+	},
+	"items": [
+		{
+			"metadata": {
+				"name": "bar-abcde",
+				"resourceVersion": "8900"
+			},
+			"addressType": "IPv4",
+			"endpoints": [
+				{
+					"addresses": ["1.2.3.4"],
+					"conditions": {"ready": true, "serving": true, "terminating": false},
+					"nodeName": "node-1",
+					"zone": "zone-a",
+					"hints": {"forZones": [{"name": "zone-a"}]}
+				},
+				{
+					"addresses": ["2.3.4.5"],
+					"conditions": {"ready": false, "serving": true, "terminating": true}
+				}
+			]
+		}
+	]
+}
+`),
+				)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				w.In.Namespace = "foo"
+				w.In.ServiceName = "bar"
+				true_, false_ := true, false
+				w.ExpOut.EndpointSliceList = &EndpointSliceList{
+					Metadata: Metadata{
+						ResourceVersion: "8910",
+					},
+					Items: []EndpointSlice{
+						{
+							Metadata: Metadata{
+								Name:            "bar-abcde",
+								ResourceVersion: "8900",
+							},
+							AddressType: AddressTypeIPv4,
+							Endpoints: []Endpoint{
+								{
+									Addresses:  []string{"1.2.3.4"},
+									Conditions: EndpointConditions{Ready: &true_, Serving: &true_, Terminating: &false_},
+									NodeName:   "node-1",
+									Zone:       "zone-a",
+									Hints:      &EndpointHints{ForZones: []ForZone{{Name: "zone-a"}}},
+								},
+								{
+									Addresses:  []string{"2.3.4.5"},
+									Conditions: EndpointConditions{Ready: &false_, Serving: &true_, Terminating: &true_},
+								},
+							},
+						},
+					},
+				}
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.MockTransport.RegisterResponder(
+					"GET",
+					"https://1.2.3.4:6443/apis/discovery.k8s.io/v1/namespaces/foo/endpointslices?labelSelector=kubernetes.io%2Fservice-name%3Dbar",
+					httpmock.NewBytesResponder(403, nil),
+				)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				w.In.Namespace = "foo"
+				w.In.ServiceName = "bar"
+				w.ExpOut.ErrStr = "get \"https://1.2.3.4:6443/apis/discovery.k8s.io/v1/namespaces/foo/endpointslices?labelSelector=kubernetes.io%2Fservice-name%3Dbar\"; statusCode=403"
+			}),
+	)
+}
+
+func TestK8sClient_WatchEndpointSlices(t *testing.T) {
+	type CallbackArgs struct {
+		EventType     EventType
+		EndpointSlice *EndpointSlice
+	}
+	type Workspace struct {
+		Init struct {
+			Fs            afero.Fs
+			MockTransport *httpmock.MockTransport
+			Env           venv.Env
+			MockClock     *clock.Mock
+		}
+		In struct {
+			Ctx             context.Context
+			Namespace       string
+			ServiceName     string
+			ResourceVersion string
+		}
+		ExpOut, ActOut struct {
+			CAs    []CallbackArgs
+			Err    error
+			ErrStr string
+		}
+		KC K8sClient
+	}
+	tc := testcase.New().
+		Step(0, func(t *testing.T, w *Workspace) {
+			w.Init.Fs = afero.NewMemMapFs()
+			w.Init.MockTransport = httpmock.NewMockTransport()
+			w.Init.Env = venv.Mock()
+			w.Init.MockClock = clock.NewMock()
+			w.Init.MockClock.Set(time.Now())
+			w.In.Ctx = context.Background()
+			err := afero.WriteFile(
+				w.Init.Fs,
+				"/var/run/secrets/kubernetes.io/serviceaccount/ca.crt",
+				[]byte(`-----BEGIN CERTIFICATE-----
+MIIBdzCCAR2gAwIBAgIBADAKBggqhkjOPQQDAjAjMSEwHwYDVQQDDBhrM3Mtc2Vy
+dmVyLWNhQDE2MjM1MDQ5MDYwHhcNMjEwNjEyMTMzNTA2WhcNMzEwNjEwMTMzNTA2
+WjAjMSEwHwYDVQQDDBhrM3Mtc2VydmVyLWNhQDE2MjM1MDQ5MDYwWTATBgcqhkjO
+PQIBBggqhkjOPQMBBwNCAAQ3qTr0SbaK0a7zf8LqavDZsV0dwTvXTnmkDa4DJ7XZ
+/zU1E1rBuCeJ4hmqnLB97k5ePamOrFEcQljOI27+2/2Qo0IwQDAOBgNVHQ8BAf8E
+BAMCAqQwDwYDVR0TAQH/BAUwAwEB/zAdBgNVHQ4EFgQUmAS4j2mFkRsIbhk2FlrO
++9eFeKswCgYIKoZIzj0EAwIDSAAwRQIgH6sg05GpW0gOrVySsQgO5LD3ythEfJte
+lO/HJTzVSS8CIQCySRrL0DQOyd2PYzqPvUq7XHuiIfRqLtLOP4+j7fDGDQ==
+-----END CERTIFICATE-----
+`),
+				644,
+			)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			w.Init.Env.Setenv("KUBERNETES_SERVICE_HOST", "1.2.3.4")
+			w.Init.Env.Setenv("KUBERNETES_SERVICE_PORT", "6443")
+			err = afero.WriteFile(
+				w.Init.Fs,
+				"/var/run/secrets/kubernetes.io/serviceaccount/namespace",
+				[]byte("default"),
+				644,
+			)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			err = afero.WriteFile(
+				w.Init.Fs,
+				"/var/run/secrets/kubernetes.io/serviceaccount/token",
+				[]byte("admin"),
+				644,
+			)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+		}).
+		Step(1, func(t *testing.T, w *Workspace) {
+			var err error
+			w.KC, err = DoNew(w.Init.Fs, func(http.RoundTripper) http.RoundTripper { return w.Init.MockTransport }, w.Init.Env, w.Init.MockClock, DummyLogger)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+		}).
+		Step(2, func(t *testing.T, w *Workspace) {
+			w.ActOut.Err = w.KC.WatchEndpointSlices(w.In.Ctx, w.In.Namespace, w.In.ServiceName, w.In.ResourceVersion, func(eventType EventType, endpointSlice *EndpointSlice) bool {
+				w.ActOut.CAs = append(w.ActOut.CAs, CallbackArgs{eventType, endpointSlice})
+				return true
+			})
+			if w.ActOut.Err != nil {
+				w.ActOut.ErrStr = w.ActOut.Err.Error()
+			}
+		}).
+		Step(3, func(t *testing.T, w *Workspace) {
+			if w.ExpOut.Err == nil || errors.Is(w.ActOut.Err, w.ExpOut.Err) {
+				w.ExpOut.Err = w.ActOut.Err
+			}
+			assert.Equal(t, w.ExpOut, w.ActOut)
+		})
+	testcase.RunListParallel(t,
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.MockTransport.RegisterResponder(
+					"GET",
+					"https://1.2.3.4:6443/apis/discovery.k8s.io/v1/namespaces/foo/endpointslices?labelSelector=kubernetes.io%2Fservice-name%3Dbar&watch=1&resourceVersion=8910",
+					httpmock.NewStringResponder(200, `{
+	"type": "MODIFIED",
+	"object": {
+		"metadata": {
+			"name": "bar-abcde",
+			"resourceVersion": "8920"
+		},
+		"endpoints": [
+			{"addresses": ["1.2.3.4"], "conditions": {"ready": true}}
+		]
+	}
+}
+{
+	"type": "DELETED",
+	"object": {
+		"metadata": {
+			"name": "bar-abcde",
+			"resourceVersion": "8930"
+		}
+	}
+}
+`),
+				)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				w.In.Namespace = "foo"
+				w.In.ServiceName = "bar"
+				w.In.ResourceVersion = "8910"
+				true_ := true
+				w.ExpOut.CAs = []CallbackArgs{
+					{
+						EventType: EventModified,
+						EndpointSlice: &EndpointSlice{
+							Metadata: Metadata{
+								Name:            "bar-abcde",
+								ResourceVersion: "8920",
+							},
+							Endpoints: []Endpoint{
+								{
+									Addresses:  []string{"1.2.3.4"},
+									Conditions: EndpointConditions{Ready: &true_},
+								},
+							},
+						},
+					},
+					{
+						EventType: EventDeleted,
+						EndpointSlice: &EndpointSlice{
+							Metadata: Metadata{
+								Name:            "bar-abcde",
+								ResourceVersion: "8930",
+							},
+						},
+					},
+				}
+				w.ExpOut.Err = io.EOF
+				w.ExpOut.ErrStr = "decode event json: EOF"
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.MockTransport.RegisterResponder(
+					"GET",
+					"https://1.2.3.4:6443/apis/discovery.k8s.io/v1/namespaces/foo/endpointslices?labelSelector=kubernetes.io%2Fservice-name%3Dbar&watch=1&resourceVersion=8910",
+					httpmock.NewBytesResponder(500, nil),
+				)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				w.In.Namespace = "foo"
+				w.In.ServiceName = "bar"
+				w.In.ResourceVersion = "8910"
+				w.ExpOut.ErrStr = "get \"https://1.2.3.4:6443/apis/discovery.k8s.io/v1/namespaces/foo/endpointslices?labelSelector=kubernetes.io%2Fservice-name%3Dbar&watch=1&resourceVersion=8910\"; statusCode=500"
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.MockTransport.RegisterResponder(
+					"GET",
+					"https://1.2.3.4:6443/apis/discovery.k8s.io/v1/namespaces/foo/endpointslices?labelSelector=kubernetes.io%2Fservice-name%3Dbar&watch=1&resourceVersion=8910",
+					httpmock.NewStringResponder(200, `{
+	"type": "ERROR",
+	"object": {
+		"code": 410,
+		"message": "gone"
+	}
+}
+`),
+				)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				w.In.Namespace = "foo"
+				w.In.ServiceName = "bar"
+				w.In.ResourceVersion = "8910"
+				w.ExpOut.Err = ErrResourceVersionExpired
+				w.ExpOut.ErrStr = "receive error event: gone"
+			}),
+	)
+}
+
 func TestK8sClient_WatchEndpoints(t *testing.T) {
 	type CallbackArgs struct {
 		EventType EventType
@@ -497,7 +1527,7 @@ lO/HJTzVSS8CIQCySRrL0DQOyd2PYzqPvUq7XHuiIfRqLtLOP4+j7fDGDQ==
 		}).
 		Step(1, func(t *testing.T, w *Workspace) {
 			var err error
-			w.KC, err = DoNew(w.Init.Fs, func(http.RoundTripper) http.RoundTripper { return w.Init.MockTransport }, w.Init.Env, w.Init.MockClock)
+			w.KC, err = DoNew(w.Init.Fs, func(http.RoundTripper) http.RoundTripper { return w.Init.MockTransport }, w.Init.Env, w.Init.MockClock, DummyLogger)
 			if !assert.NoError(t, err) {
 				t.FailNow()
 			}
@@ -849,6 +1879,227 @@ lO/HJTzVSS8CIQCySRrL0DQOyd2PYzqPvUq7XHuiIfRqLtLOP4+j7fDGDQ==
 	)
 }
 
+func TestK8sClient_WatchService(t *testing.T) {
+	type CallbackArgs struct {
+		EventType EventType
+		Service   *Service
+	}
+	type Workspace struct {
+		Init struct {
+			Fs            afero.Fs
+			MockTransport *httpmock.MockTransport
+			Env           venv.Env
+			MockClock     *clock.Mock
+		}
+		In struct {
+			Ctx             context.Context
+			Namespace       string
+			ServiceName     string
+			ResourceVersion string
+		}
+		ExpOut, ActOut struct {
+			CAs    []CallbackArgs
+			Err    error
+			ErrStr string
+		}
+		KC K8sClient
+	}
+	tc := testcase.New().
+		Step(0, func(t *testing.T, w *Workspace) {
+			w.Init.Fs = afero.NewMemMapFs()
+			w.Init.MockTransport = httpmock.NewMockTransport()
+			w.Init.Env = venv.Mock()
+			w.Init.MockClock = clock.NewMock()
+			w.Init.MockClock.Set(time.Now())
+			w.In.Ctx = context.Background()
+			err := afero.WriteFile(
+				w.Init.Fs,
+				"/var/run/secrets/kubernetes.io/serviceaccount/ca.crt",
+				[]byte(`-----BEGIN CERTIFICATE-----
+MIIBdzCCAR2gAwIBAgIBADAKBggqhkjOPQQDAjAjMSEwHwYDVQQDDBhrM3Mtc2Vy
+dmVyLWNhQDE2MjM1MDQ5MDYwHhcNMjEwNjEyMTMzNTA2WhcNMzEwNjEwMTMzNTA2
+WjAjMSEwHwYDVQQDDBhrM3Mtc2VydmVyLWNhQDE2MjM1MDQ5MDYwWTATBgcqhkjO
+PQIBBggqhkjOPQMBBwNCAAQ3qTr0SbaK0a7zf8LqavDZsV0dwTvXTnmkDa4DJ7XZ
+/zU1E1rBuCeJ4hmqnLB97k5ePamOrFEcQljOI27+2/2Qo0IwQDAOBgNVHQ8BAf8E
+BAMCAqQwDwYDVR0TAQH/BAUwAwEB/zAdBgNVHQ4EFgQUmAS4j2mFkRsIbhk2FlrO
++9eFeKswCgYIKoZIzj0EAwIDSAAwRQIgH6sg05GpW0gOrVySsQgO5LD3ythEfJte
+lO/HJTzVSS8CIQCySRrL0DQOyd2PYzqPvUq7XHuiIfRqLtLOP4+j7fDGDQ==
+-----END CERTIFICATE-----
+`),
+				644,
+			)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			w.Init.Env.Setenv("KUBERNETES_SERVICE_HOST", "1.2.3.4")
+			w.Init.Env.Setenv("KUBERNETES_SERVICE_PORT", "6443")
+			err = afero.WriteFile(
+				w.Init.Fs,
+				"/var/run/secrets/kubernetes.io/serviceaccount/namespace",
+				[]byte("default"),
+				644,
+			)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			err = afero.WriteFile(
+				w.Init.Fs,
+				"/var/run/secrets/kubernetes.io/serviceaccount/token",
+				[]byte("admin"),
+				644,
+			)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+		}).
+		Step(1, func(t *testing.T, w *Workspace) {
+			var err error
+			w.KC, err = DoNew(w.Init.Fs, func(http.RoundTripper) http.RoundTripper { return w.Init.MockTransport }, w.Init.Env, w.Init.MockClock, DummyLogger)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+		}).
+		Step(2, func(t *testing.T, w *Workspace) {
+			w.ActOut.Err = w.KC.WatchService(w.In.Ctx, w.In.Namespace, w.In.ServiceName, w.In.ResourceVersion, func(eventType EventType, service *Service) bool {
+				w.ActOut.CAs = append(w.ActOut.CAs, CallbackArgs{eventType, service})
+				return true
+			})
+			if w.ActOut.Err != nil {
+				w.ActOut.ErrStr = w.ActOut.Err.Error()
+			}
+		}).
+		Step(3, func(t *testing.T, w *Workspace) {
+			if w.ExpOut.Err == nil || errors.Is(w.ActOut.Err, w.ExpOut.Err) {
+				w.ExpOut.Err = w.ActOut.Err
+			}
+			assert.Equal(t, w.ExpOut, w.ActOut)
+		})
+	testcase.RunListParallel(t,
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.MockTransport.RegisterResponder(
+					"GET",
+					"https://1.2.3.4:6443/api/v1/watch/namespaces/foo/services/bar?resourceVersion=8910",
+					httpmock.NewStringResponder(200, `{
+	"type": "MODIFIED",
+	"object": {
+		"metadata": {
+			"resourceVersion": "8920"
+		},
+		"spec": {
+			"sessionAffinity": "ClientIP"
+		}
+	}
+}
+{
+	"type": "DELETED",
+	"object": {
+		"metadata": {
+			"resourceVersion": "8930"
+		}
+	}
+}
+`),
+				)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				w.In.Namespace = "foo"
+				w.In.ServiceName = "bar"
+				w.In.ResourceVersion = "8910"
+				w.ExpOut.CAs = []CallbackArgs{
+					{
+						EventType: EventModified,
+						Service: &Service{
+							Metadata: Metadata{
+								ResourceVersion: "8920",
+							},
+							Spec: ServiceSpec{
+								SessionAffinity: SessionAffinityClientIP,
+							},
+						},
+					},
+					{
+						EventType: EventDeleted,
+						Service: &Service{
+							Metadata: Metadata{
+								ResourceVersion: "8930",
+							},
+						},
+					},
+				}
+				w.ExpOut.Err = io.EOF
+				w.ExpOut.ErrStr = "decode event json: EOF"
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.MockTransport.RegisterResponder(
+					"GET",
+					"https://1.2.3.4:6443/api/v1/watch/namespaces/foo/services/bar?resourceVersion=8910",
+					httpmock.NewStringResponder(200, `{
+	"type": "ERROR",
+	"object": {
+		"code": 410,
+		"message": "gone"
+	}
+}
+`),
+				)
+				w.Init.MockTransport.RegisterResponder(
+					"GET",
+					"https://1.2.3.4:6443/api/v1/watch/namespaces/foo/services/bar",
+					httpmock.NewStringResponder(200, `{
+	"type": "ADDED",
+	"object": {
+		"metadata": {
+			"resourceVersion": "8910"
+		}
+	}
+}
+{
+	"type": "ADDED",
+	"object": {
+		"metadata": {
+			"resourceVersion": "8999"
+		}
+	}
+}
+`),
+				)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				w.In.Namespace = "foo"
+				w.In.ServiceName = "bar"
+				w.In.ResourceVersion = "8910"
+				w.ExpOut.CAs = []CallbackArgs{
+					{
+						EventType: EventAdded,
+						Service: &Service{
+							Metadata: Metadata{
+								ResourceVersion: "8999",
+							},
+						},
+					},
+				}
+				w.ExpOut.Err = io.EOF
+				w.ExpOut.ErrStr = "decode event json: EOF"
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.MockTransport.RegisterResponder(
+					"GET",
+					"https://1.2.3.4:6443/api/v1/watch/namespaces/foo/services/bar?resourceVersion=8910",
+					httpmock.NewBytesResponder(403, nil),
+				)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				w.In.Namespace = "foo"
+				w.In.ServiceName = "bar"
+				w.In.ResourceVersion = "8910"
+				w.ExpOut.ErrStr = "get \"https://1.2.3.4:6443/api/v1/watch/namespaces/foo/services/bar?resourceVersion=8910\"; statusCode=403"
+			}),
+	)
+}
+
 func TestToken_Get(t *testing.T) {
 	type Workspace struct {
 		In struct {
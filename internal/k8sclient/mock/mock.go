@@ -50,6 +50,66 @@ func (mr *MockK8sClientMockRecorder) GetEndpoints(arg0, arg1, arg2 interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEndpoints", reflect.TypeOf((*MockK8sClient)(nil).GetEndpoints), arg0, arg1, arg2)
 }
 
+// GetNode mocks base method.
+func (m *MockK8sClient) GetNode(arg0 context.Context, arg1 string) (*k8sclient.Node, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetNode", arg0, arg1)
+	ret0, _ := ret[0].(*k8sclient.Node)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetNode indicates an expected call of GetNode.
+func (mr *MockK8sClientMockRecorder) GetNode(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNode", reflect.TypeOf((*MockK8sClient)(nil).GetNode), arg0, arg1)
+}
+
+// GetService mocks base method.
+func (m *MockK8sClient) GetService(arg0 context.Context, arg1, arg2 string) (*k8sclient.Service, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetService", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*k8sclient.Service)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetService indicates an expected call of GetService.
+func (mr *MockK8sClientMockRecorder) GetService(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetService", reflect.TypeOf((*MockK8sClient)(nil).GetService), arg0, arg1, arg2)
+}
+
+// ListEndpointSlices mocks base method.
+func (m *MockK8sClient) ListEndpointSlices(arg0 context.Context, arg1, arg2 string) (*k8sclient.EndpointSliceList, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListEndpointSlices", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*k8sclient.EndpointSliceList)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListEndpointSlices indicates an expected call of ListEndpointSlices.
+func (mr *MockK8sClientMockRecorder) ListEndpointSlices(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListEndpointSlices", reflect.TypeOf((*MockK8sClient)(nil).ListEndpointSlices), arg0, arg1, arg2)
+}
+
+// ListPods mocks base method.
+func (m *MockK8sClient) ListPods(arg0 context.Context, arg1, arg2 string) (*k8sclient.PodList, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListPods", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*k8sclient.PodList)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListPods indicates an expected call of ListPods.
+func (mr *MockK8sClientMockRecorder) ListPods(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListPods", reflect.TypeOf((*MockK8sClient)(nil).ListPods), arg0, arg1, arg2)
+}
+
 // Namespace mocks base method.
 func (m *MockK8sClient) Namespace() string {
 	m.ctrl.T.Helper()
@@ -64,6 +124,20 @@ func (mr *MockK8sClientMockRecorder) Namespace() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Namespace", reflect.TypeOf((*MockK8sClient)(nil).Namespace))
 }
 
+// WatchEndpointSlices mocks base method.
+func (m *MockK8sClient) WatchEndpointSlices(arg0 context.Context, arg1, arg2, arg3 string, arg4 k8sclient.WatchEndpointSlicesCallback) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WatchEndpointSlices", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WatchEndpointSlices indicates an expected call of WatchEndpointSlices.
+func (mr *MockK8sClientMockRecorder) WatchEndpointSlices(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WatchEndpointSlices", reflect.TypeOf((*MockK8sClient)(nil).WatchEndpointSlices), arg0, arg1, arg2, arg3, arg4)
+}
+
 // WatchEndpoints mocks base method.
 func (m *MockK8sClient) WatchEndpoints(arg0 context.Context, arg1, arg2, arg3 string, arg4 k8sclient.WatchEndpointsCallback) error {
 	m.ctrl.T.Helper()
@@ -77,3 +151,31 @@ func (mr *MockK8sClientMockRecorder) WatchEndpoints(arg0, arg1, arg2, arg3, arg4
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WatchEndpoints", reflect.TypeOf((*MockK8sClient)(nil).WatchEndpoints), arg0, arg1, arg2, arg3, arg4)
 }
+
+// WatchPods mocks base method.
+func (m *MockK8sClient) WatchPods(arg0 context.Context, arg1, arg2, arg3 string, arg4 k8sclient.WatchPodsCallback) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WatchPods", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WatchPods indicates an expected call of WatchPods.
+func (mr *MockK8sClientMockRecorder) WatchPods(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WatchPods", reflect.TypeOf((*MockK8sClient)(nil).WatchPods), arg0, arg1, arg2, arg3, arg4)
+}
+
+// WatchService mocks base method.
+func (m *MockK8sClient) WatchService(arg0 context.Context, arg1, arg2, arg3 string, arg4 k8sclient.WatchServiceCallback) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WatchService", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WatchService indicates an expected call of WatchService.
+func (mr *MockK8sClientMockRecorder) WatchService(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WatchService", reflect.TypeOf((*MockK8sClient)(nil).WatchService), arg0, arg1, arg2, arg3, arg4)
+}
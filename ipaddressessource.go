@@ -2,7 +2,11 @@ package kubetransport
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"sync"
+	"time"
 
 	"github.com/go-tk/kubetransport/internal/k8sclient"
 )
@@ -11,9 +15,26 @@ type ipAddressesSource struct {
 	backgroundCtx context.Context
 	stop          context.CancelFunc
 	k8sClient     k8sclient.K8sClient
+	options       *options
 	namespace     string
 	endpointsName string
+	eventHistory  *eventHistory
 	valueCallback ipAddressesCallback
+
+	resourceVersion  string
+	nodeNames        map[string]string
+	hostnames        map[string]string
+	podRefs          map[string]podRef
+	notReadyValue    []string
+	terminatingValue []string
+
+	endpointTopologies map[string]endpointTopology
+
+	serviceLock sync.Mutex
+	service     *k8sclient.Service
+
+	podLabelsOnce sync.Once
+	podLabels     *podLabels
 }
 
 type ipAddressesCallback func(ipAddressesSource *ipAddressesSource, ipAddresses []string, err error)
@@ -21,44 +42,185 @@ type ipAddressesCallback func(ipAddressesSource *ipAddressesSource, ipAddresses
 func newIPAddressesSource(
 	backgroundCtx context.Context,
 	k8sClient k8sclient.K8sClient,
+	options *options,
 	namespace string,
 	endpointsName string,
+	eventHistory *eventHistory,
 	valueCallback ipAddressesCallback,
 ) *ipAddressesSource {
 	var ipas ipAddressesSource
 	ipas.backgroundCtx, ipas.stop = context.WithCancel(backgroundCtx)
 	ipas.k8sClient = k8sClient
+	ipas.options = options
 	ipas.namespace = namespace
 	ipas.endpointsName = endpointsName
+	ipas.eventHistory = eventHistory
 	ipas.valueCallback = valueCallback
 	go ipas.getValuesAndSetWatch()
 	return &ipas
 }
 
 func (ipas *ipAddressesSource) getValuesAndSetWatch() {
+	if ipas.options.ServiceLookup {
+		// The Service is got concurrently with the endpoints, but the first value is not
+		// reported until the Service is got (or failed to), so that it is known by then.
+		serviceGot := make(chan struct{})
+		go func() {
+			ipas.getAndWatchService()
+			close(serviceGot)
+		}()
+		valueCallback := ipas.valueCallback
+		ipas.valueCallback = func(ipas *ipAddressesSource, ipAddresses []string, err error) {
+			<-serviceGot
+			valueCallback(ipas, ipAddresses, err)
+		}
+	}
+	if ipas.options.EndpointSlices {
+		ipas.getValuesAndSetWatchWithEndpointSlices()
+		return
+	}
 	endpoints, err := ipas.k8sClient.GetEndpoints(ipas.backgroundCtx, ipas.namespace, ipas.endpointsName)
 	if err != nil {
+		ipas.reportError(err)
 		ipas.valueCallback(ipas, nil, fmt.Errorf("get endpoints; namespace=%q endpointsName=%q: %w", ipas.namespace, ipas.endpointsName, err))
 		return
 	}
-	var value []string
-	var resourceVersion string
+	value := ipas.setEndpoints(endpoints)
 	if endpoints != nil {
-		value = extractIPAddresses(endpoints)
-		resourceVersion = endpoints.Metadata.ResourceVersion
+		ipas.resourceVersion = endpoints.Metadata.ResourceVersion
 	}
+	ipas.options.Logger.Debug("got endpoints", ipas.logArgs("found", endpoints != nil, "addressCount", len(value))...)
+	ipas.eventHistory.RecordIPAddresses(historyEventGet, ipas.resourceVersion, value)
 	ipas.valueCallback(ipas, value, nil)
-	err = ipas.k8sClient.WatchEndpoints(ipas.backgroundCtx, ipas.namespace, ipas.endpointsName, resourceVersion, func(eventType k8sclient.EventType, endpoints *k8sclient.Endpoints) bool {
+	callback := func(eventType k8sclient.EventType, endpoints *k8sclient.Endpoints) bool {
 		var value []string
 		if eventType != k8sclient.EventDeleted {
-			value = extractIPAddresses(endpoints)
+			value = ipas.setEndpoints(endpoints)
+		} else {
+			ipas.setEndpoints(nil)
+		}
+		if endpoints != nil {
+			ipas.resourceVersion = endpoints.Metadata.ResourceVersion
+			if eventType != k8sclient.EventDeleted {
+				ipas.observePropagationDelay(endpoints)
+			}
 		}
+		ipas.options.Logger.Debug("received endpoints event", ipas.logArgs("eventType", eventType, "addressCount", len(value))...)
+		ipas.eventHistory.RecordIPAddresses(string(eventType), ipas.resourceVersion, value)
 		ipas.valueCallback(ipas, value, nil)
 		return true
+	}
+	err = ipas.watch(func() error {
+		return ipas.k8sClient.WatchEndpoints(ipas.backgroundCtx, ipas.namespace, ipas.endpointsName, ipas.resourceVersion, callback)
 	})
+	ipas.reportError(err)
 	ipas.valueCallback(ipas, nil, fmt.Errorf("watch endpoints; namespace=%q endpointsName=%q: %w", ipas.namespace, ipas.endpointsName, err))
 }
 
+// minWatchDuration is the minimum duration of a watch closed by the API server to be
+// considered expired rather than failed.
+const minWatchDuration = 1 * time.Second
+
+// watch calls the given function to watch and calls it again whenever the watch expires, as
+// the API server closes watches on its timeout, until the watch fails or is stopped.
+func (ipas *ipAddressesSource) watch(doWatch func() error) error {
+	for {
+		t0 := time.Now()
+		err := doWatch()
+		if !errors.Is(err, io.EOF) || time.Since(t0) < minWatchDuration || ipas.IsStopped() {
+			return err
+		}
+		ipas.options.Logger.Debug("watch expired, rewatching", ipas.keyLogArgs()...)
+	}
+}
+
+// serviceRetryInterval is the interval to wait before getting a Service again after a
+// failure.
+const serviceRetryInterval = 1 * time.Minute
+
+// getAndWatchService gets the Service of the endpoints, and then keeps it up to date in the
+// background.
+func (ipas *ipAddressesSource) getAndWatchService() {
+	resourceVersion, ok := ipas.getService()
+	go func() {
+		for {
+			if ok {
+				err := ipas.watch(func() error {
+					return ipas.k8sClient.WatchService(ipas.backgroundCtx, ipas.namespace, ipas.endpointsName, resourceVersion, func(eventType k8sclient.EventType, service *k8sclient.Service) bool {
+						if service == nil {
+							return true
+						}
+						resourceVersion = service.Metadata.ResourceVersion
+						if eventType == k8sclient.EventDeleted {
+							service = nil
+						}
+						ipas.options.Logger.Debug("received service event", ipas.keyLogArgs("eventType", eventType)...)
+						ipas.setService(service)
+						return true
+					})
+				})
+				if ipas.IsStopped() {
+					return
+				}
+				ipas.options.Logger.Warn("service watch failed", ipas.keyLogArgs("error", err)...)
+			}
+			select {
+			case <-ipas.backgroundCtx.Done():
+				return
+			case <-time.After(serviceRetryInterval):
+			}
+			resourceVersion, ok = ipas.getService()
+		}
+	}()
+}
+
+// getService gets the Service of the endpoints and returns its resource version, and false if
+// getting it failed.
+func (ipas *ipAddressesSource) getService() (string, bool) {
+	service, err := ipas.k8sClient.GetService(ipas.backgroundCtx, ipas.namespace, ipas.endpointsName)
+	if err != nil {
+		if !ipas.IsStopped() {
+			ipas.options.Logger.Warn("failed to get service", ipas.keyLogArgs("error", err)...)
+		}
+		return "", false
+	}
+	ipas.options.Logger.Debug("got service", ipas.keyLogArgs("found", service != nil)...)
+	ipas.setService(service)
+	if service == nil {
+		return "", true
+	}
+	return service.Metadata.ResourceVersion, true
+}
+
+func (ipas *ipAddressesSource) setService(service *k8sclient.Service) {
+	ipas.serviceLock.Lock()
+	ipas.service = service
+	ipas.serviceLock.Unlock()
+}
+
+// keyLogArgs likes logArgs but leaves out the resource version of the endpoints, which
+// is not safe to read outside the callback.
+func (ipas *ipAddressesSource) keyLogArgs(args ...interface{}) []interface{} {
+	return endpointKey{ipas.namespace, ipas.endpointsName}.LogArgs(args...)
+}
+
+// setEndpoints extracts the information of the given endpoints, which is nil if the endpoints
+// do not exist, and returns the ip addresses.
+func (ipas *ipAddressesSource) setEndpoints(endpoints *k8sclient.Endpoints) []string {
+	if endpoints == nil {
+		ipas.nodeNames = nil
+		ipas.hostnames = nil
+		ipas.podRefs = nil
+		ipas.notReadyValue = nil
+		return nil
+	}
+	ipas.nodeNames = extractNodeNames(endpoints)
+	ipas.hostnames = extractHostnames(endpoints)
+	ipas.podRefs = extractPodRefs(endpoints, ipas.namespace)
+	ipas.notReadyValue = extractNotReadyIPAddresses(endpoints)
+	return extractIPAddresses(endpoints)
+}
+
 func extractIPAddresses(endpoints *k8sclient.Endpoints) []string {
 	var i int
 	for j := range endpoints.Subsets {
@@ -78,4 +240,177 @@ func extractIPAddresses(endpoints *k8sclient.Endpoints) []string {
 	return ipAddresses
 }
 
+// extractNotReadyIPAddresses returns the ip addresses of the endpoint addresses which are
+// not ready, or nil if none.
+func extractNotReadyIPAddresses(endpoints *k8sclient.Endpoints) []string {
+	var ipAddresses []string
+	for j := range endpoints.Subsets {
+		endpointSubset := &endpoints.Subsets[j]
+		for k := range endpointSubset.NotReadyAddresses {
+			ipAddresses = append(ipAddresses, endpointSubset.NotReadyAddresses[k].IP)
+		}
+	}
+	return ipAddresses
+}
+
+// extractNodeNames returns the names of the nodes of the endpoint addresses by ip address.
+func extractNodeNames(endpoints *k8sclient.Endpoints) map[string]string {
+	var nodeNames map[string]string
+	for j := range endpoints.Subsets {
+		endpointSubset := &endpoints.Subsets[j]
+		for k := range endpointSubset.Addresses {
+			endpointAddress := &endpointSubset.Addresses[k]
+			if endpointAddress.NodeName == "" {
+				continue
+			}
+			if nodeNames == nil {
+				nodeNames = make(map[string]string)
+			}
+			nodeNames[endpointAddress.IP] = endpointAddress.NodeName
+		}
+	}
+	return nodeNames
+}
+
+// extractHostnames returns the ip addresses of the endpoint addresses, whether ready or not,
+// by hostname, for StatefulSets and headless services, as well as by the name of the pod
+// referenced. Ready addresses take precedence over not-ready ones.
+func extractHostnames(endpoints *k8sclient.Endpoints) map[string]string {
+	var hostnames map[string]string
+	add := func(hostname string, ipAddress string) {
+		if hostname == "" {
+			return
+		}
+		if hostnames == nil {
+			hostnames = make(map[string]string)
+		}
+		hostnames[hostname] = ipAddress
+	}
+	addAll := func(endpointAddresses []k8sclient.EndpointAddress) {
+		for k := range endpointAddresses {
+			endpointAddress := &endpointAddresses[k]
+			if targetRef := endpointAddress.TargetRef; targetRef != nil && targetRef.Kind == "Pod" {
+				add(targetRef.Name, endpointAddress.IP)
+			}
+		}
+		// Hostnames take precedence over pod names.
+		for k := range endpointAddresses {
+			endpointAddress := &endpointAddresses[k]
+			add(endpointAddress.Hostname, endpointAddress.IP)
+		}
+	}
+	for j := range endpoints.Subsets {
+		addAll(endpoints.Subsets[j].NotReadyAddresses)
+	}
+	for j := range endpoints.Subsets {
+		addAll(endpoints.Subsets[j].Addresses)
+	}
+	return hostnames
+}
+
+// extractPodRefs returns the references to the pods of the endpoint addresses, whether
+// ready or not, by ip address.
+func extractPodRefs(endpoints *k8sclient.Endpoints, namespace string) map[string]podRef {
+	var podRefs map[string]podRef
+	add := func(endpointAddress *k8sclient.EndpointAddress) {
+		targetRef := endpointAddress.TargetRef
+		if targetRef == nil || targetRef.Kind != "Pod" {
+			return
+		}
+		if podRefs == nil {
+			podRefs = make(map[string]podRef)
+		}
+		podRef := podRef{targetRef.Namespace, targetRef.Name}
+		if podRef.Namespace == "" {
+			podRef.Namespace = namespace
+		}
+		podRefs[endpointAddress.IP] = podRef
+	}
+	for j := range endpoints.Subsets {
+		endpointSubset := &endpoints.Subsets[j]
+		for k := range endpointSubset.Addresses {
+			add(&endpointSubset.Addresses[k])
+		}
+		for k := range endpointSubset.NotReadyAddresses {
+			add(&endpointSubset.NotReadyAddresses[k])
+		}
+	}
+	return podRefs
+}
+
+// lastChangeTriggerTimeAnnotation is the annotation set by Kubernetes on endpoints to the time
+// of the last change (e.g. pod or service change) which triggered the endpoints change.
+const lastChangeTriggerTimeAnnotation = "endpoints.kubernetes.io/last-change-trigger-time"
+
+func (ipas *ipAddressesSource) observePropagationDelay(endpoints *k8sclient.Endpoints) {
+	value, ok := endpoints.Metadata.Annotations[lastChangeTriggerTimeAnnotation]
+	if !ok {
+		return
+	}
+	lastChangeTriggerTime, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		ipas.options.Logger.Debug("invalid last change trigger time", ipas.logArgs("value", value, "error", err)...)
+		return
+	}
+	propagationDelay := time.Since(lastChangeTriggerTime)
+	if propagationDelay < 0 {
+		// clock skew
+		propagationDelay = 0
+	}
+	ipas.options.MetricsCollector.ObserveHistogram(MetricPropagationDelay, endpointsLabels(ipas.namespace, ipas.endpointsName), propagationDelay.Seconds())
+}
+
+func (ipas *ipAddressesSource) logArgs(args ...interface{}) []interface{} {
+	return append([]interface{}{"namespace", ipas.namespace, "endpointsName", ipas.endpointsName, "resourceVersion", ipas.resourceVersion}, args...)
+}
+
+func (ipas *ipAddressesSource) reportError(err error) {
+	if ipas.IsStopped() {
+		return
+	}
+	ipas.eventHistory.RecordError(ipas.resourceVersion, err)
+	ipas.options.MetricsCollector.AddCounter(MetricWatchErrors, endpointsLabels(ipas.namespace, ipas.endpointsName), 1)
+}
+
 func (ipas *ipAddressesSource) Stop() { ipas.stop() }
+
+// ResourceVersion returns the resource version of the endpoints last received.
+// It should only be called in the callback.
+func (ipas *ipAddressesSource) ResourceVersion() string { return ipas.resourceVersion }
+
+// NodeNames returns the names of the nodes of the endpoints last received by ip address.
+// It should only be called in the callback.
+func (ipas *ipAddressesSource) NodeNames() map[string]string { return ipas.nodeNames }
+
+// NotReadyValue returns the ip addresses of the endpoints last received which are not ready.
+// It should only be called in the callback.
+func (ipas *ipAddressesSource) NotReadyValue() []string { return ipas.notReadyValue }
+
+// TerminatingValue returns the ip addresses of the endpoints last received which are
+// terminating but still serving. It should only be called in the callback.
+func (ipas *ipAddressesSource) TerminatingValue() []string { return ipas.terminatingValue }
+
+// EndpointTopologies returns the topologies of the endpoints last received by ip address,
+// which are only known from EndpointSlices. It should only be called in the callback.
+func (ipas *ipAddressesSource) EndpointTopologies() map[string]endpointTopology {
+	return ipas.endpointTopologies
+}
+
+// Hostnames returns the ip addresses of the endpoints last received by hostname (or pod name).
+// It should only be called in the callback.
+func (ipas *ipAddressesSource) Hostnames() map[string]string { return ipas.hostnames }
+
+// PodRefs returns the references to the pods of the endpoints last received by ip address.
+// It should only be called in the callback.
+func (ipas *ipAddressesSource) PodRefs() map[string]podRef { return ipas.podRefs }
+
+// Service returns the Service of the endpoints, or nil if the service lookup is disabled,
+// the Service does not exist or getting it failed. It is kept up to date by watching the
+// Service, and is safe to call at any time.
+func (ipas *ipAddressesSource) Service() *k8sclient.Service {
+	ipas.serviceLock.Lock()
+	defer ipas.serviceLock.Unlock()
+	return ipas.service
+}
+
+func (ipas *ipAddressesSource) IsStopped() bool { return ipas.backgroundCtx.Err() != nil }
@@ -3,7 +3,9 @@ package kubetransport_test
 import (
 	"context"
 	"errors"
+	"net/http/httptest"
 	"testing"
+	"time"
 	"unsafe"
 
 	. "github.com/go-tk/kubetransport"
@@ -25,14 +27,17 @@ func TestNewIPAddressesSource(t *testing.T) {
 		Init struct {
 			BackgroundCtx context.Context
 			MockK8sClient *mock_k8sclient.MockK8sClient
+			Options       Options
 			Namespace     string
 			EndpointsName string
 		}
 		ExpOut, ActOut struct {
-			CAs []CallbackArgs
+			CAs     []CallbackArgs
+			History []HistoryEntry
 		}
 
 		CAs  chan CallbackArgs
+		EH   *EventHistory
 		IPAS *IPAddressesSource
 	}
 	tc := testcase.New().
@@ -40,6 +45,7 @@ func TestNewIPAddressesSource(t *testing.T) {
 			w.Init.BackgroundCtx = context.Background()
 			ctrl := gomock.NewController(t)
 			w.Init.MockK8sClient = mock_k8sclient.NewMockK8sClient(ctrl)
+			w.Init.Options = MakeOptions(nil)
 		}).
 		Step(1, func(t *testing.T, w *Workspace) {
 			ipAddressesCallback := func(ipAddressesSource *IPAddressesSource, ipAddresses []string, err error) {
@@ -58,13 +64,20 @@ func TestNewIPAddressesSource(t *testing.T) {
 				}
 			}
 			w.CAs = make(chan CallbackArgs)
-			w.IPAS = NewIPAddressesSource(w.Init.BackgroundCtx, w.Init.MockK8sClient, w.Init.Namespace, w.Init.EndpointsName, ipAddressesCallback)
+			w.EH = NewEventHistory()
+			w.IPAS = NewIPAddressesSource(w.Init.BackgroundCtx, w.Init.MockK8sClient, &w.Init.Options, w.Init.Namespace, w.Init.EndpointsName, w.EH, ipAddressesCallback)
 			t.Cleanup(w.IPAS.Stop)
 		}).
 		Step(2, func(t *testing.T, w *Workspace) {
 			for ca := range w.CAs {
 				w.ActOut.CAs = append(w.ActOut.CAs, ca)
 			}
+			w.ActOut.History = w.EH.Entries()
+			for i := range w.ActOut.History {
+				entry := &w.ActOut.History[i]
+				assert.False(t, entry.Time.IsZero())
+				entry.Time = time.Time{}
+			}
 			if !assert.Len(t, w.ActOut.CAs, len(w.ExpOut.CAs)) {
 				t.FailNow()
 			}
@@ -83,6 +96,15 @@ func TestNewIPAddressesSource(t *testing.T) {
 			Step(0.5, func(t *testing.T, w *Workspace) {
 				w.Init.Namespace = "foo"
 				w.Init.EndpointsName = "bar"
+				metrics := NewMetrics()
+				w.Init.Options.MetricsCollector = metrics
+				t.Cleanup(func() {
+					responseRecorder := httptest.NewRecorder()
+					metrics.ServeHTTP(responseRecorder, nil)
+					assert.Contains(t, responseRecorder.Body.String(), `kubetransport_propagation_delay_seconds_bucket{namespace="foo",endpoints_name="bar",le="1"} 0`)
+					assert.Contains(t, responseRecorder.Body.String(), `kubetransport_propagation_delay_seconds_bucket{namespace="foo",endpoints_name="bar",le="2.5"} 1`)
+					assert.Contains(t, responseRecorder.Body.String(), `kubetransport_propagation_delay_seconds_count{namespace="foo",endpoints_name="bar"} 1`)
+				})
 				w.Init.MockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("foo"), gomock.Eq("bar")).
 					DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
 						return nil, nil
@@ -90,6 +112,11 @@ func TestNewIPAddressesSource(t *testing.T) {
 				w.Init.MockK8sClient.EXPECT().WatchEndpoints(gomock.Any(), gomock.Eq("foo"), gomock.Eq("bar"), gomock.Any(), gomock.Any()).
 					DoAndReturn(func(ctx context.Context, namespace, endpointsName, resourceVersion string, callback k8sclient.WatchEndpointsCallback) error {
 						callback(k8sclient.EventAdded, &k8sclient.Endpoints{
+							Metadata: k8sclient.Metadata{
+								Annotations: map[string]string{
+									"endpoints.kubernetes.io/last-change-trigger-time": time.Now().Add(-2 * time.Second).UTC().Format(time.RFC3339Nano),
+								},
+							},
 							Subsets: []k8sclient.EndpointSubset{
 								{
 									Addresses: []k8sclient.EndpointAddress{
@@ -139,6 +166,11 @@ func TestNewIPAddressesSource(t *testing.T) {
 						ErrStr: "watch endpoints; namespace=\"foo\" endpointsName=\"bar\": context canceled",
 					},
 				}
+				w.ExpOut.History = []HistoryEntry{
+					{EventType: "GET"},
+					{EventType: "ADDED", AddedIPAddresses: []string{"1.2.3.4", "2.3.4.5", "7.7.7.7", "8.8.8.8"}},
+					{EventType: "MODIFIED", AddedIPAddresses: []string{"9.9.9.9"}, RemovedIPAddresses: []string{"2.3.4.5", "7.7.7.7"}},
+				}
 			}),
 		tc.Copy().
 			Step(0.5, func(t *testing.T, w *Workspace) {
@@ -207,6 +239,12 @@ func TestNewIPAddressesSource(t *testing.T) {
 						ErrStr: "watch endpoints; namespace=\"foo\" endpointsName=\"bar\": context canceled",
 					},
 				}
+				w.ExpOut.History = []HistoryEntry{
+					{EventType: "GET", ResourceVersion: "8910", AddedIPAddresses: []string{"1.2.3.4", "2.3.4.5", "7.7.7.7", "8.8.8.8"}},
+					{EventType: "ADDED", AddedIPAddresses: []string{"9.9.9.9"}, RemovedIPAddresses: []string{"2.3.4.5", "7.7.7.7"}},
+					{EventType: "MODIFIED", RemovedIPAddresses: []string{"1.2.3.4", "8.8.8.8", "9.9.9.9"}},
+					{EventType: "DELETED"},
+				}
 			}),
 		tc.Copy().
 			Step(0.5, func(t *testing.T, w *Workspace) {
@@ -228,6 +266,7 @@ func TestNewIPAddressesSource(t *testing.T) {
 						ErrStr: "get endpoints; namespace=\"foo\" endpointsName=\"bar\": context deadline exceeded",
 					},
 				}
+				w.ExpOut.History = []HistoryEntry{}
 			}),
 	)
 }
@@ -1,44 +1,287 @@
 package kubetransport
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type kubeTransport struct {
 	endpointsRegistry *endpointsRegistry
+	stateRegistry     *stateRegistry
+	options           *options
 	transport         http.RoundTripper
 	seed              uint64
+
+	idleConnectionCloser *idleConnectionCloser
 }
 
 var _ http.RoundTripper = (*kubeTransport)(nil)
 
-func newKubeTransport(endpointsRegistry *endpointsRegistry, transport http.RoundTripper, seed uint64) *kubeTransport {
+func newKubeTransport(
+	endpointsRegistry *endpointsRegistry,
+	stateRegistry *stateRegistry,
+	options *options,
+	transport http.RoundTripper,
+	seed uint64,
+) *kubeTransport {
 	var kt kubeTransport
 	kt.endpointsRegistry = endpointsRegistry
+	kt.stateRegistry = stateRegistry
+	kt.options = options
 	kt.transport = transport
 	kt.seed = seed
+	if options.CloseIdleConnections {
+		idleConnectionCloser := newIdleConnectionCloser(transport)
+		removeRemovalCallback := endpointsRegistry.AddRemovalCallback(func(_ endpointKey, ipAddresses []string) {
+			var unusedIPAddresses []string
+			for _, ipAddress := range ipAddresses {
+				if !endpointsRegistry.IsIPAddressInUse(ipAddress) {
+					unusedIPAddresses = append(unusedIPAddresses, ipAddress)
+				}
+			}
+			if len(unusedIPAddresses) >= 1 {
+				idleConnectionCloser.RemoveIPAddresses(unusedIPAddresses)
+			}
+		})
+		// The removal callback refers to the idle connection closer only, not to the
+		// transport, so that the transport can be garbage collected and then the callback
+		// removed.
+		runtime.SetFinalizer(&kt, func(*kubeTransport) { removeRemovalCallback() })
+		kt.idleConnectionCloser = idleConnectionCloser
+	}
 	return &kt
 }
 
 func (kt *kubeTransport) RoundTrip(request *http.Request) (*http.Response, error) {
-	if err := kt.resolveHostname(request); err != nil {
-		return nil, err
-	}
-	return kt.transport.RoundTrip(request)
-}
-
-func (kt *kubeTransport) resolveHostname(request *http.Request) error {
 	url := request.URL
 	const schemePrefix = "kube-"
 	if !strings.HasPrefix(url.Scheme, schemePrefix) {
-		return nil
+		return kt.transport.RoundTrip(request)
 	}
 	url.Scheme = url.Scheme[len(schemePrefix):]
-	hostname := url.Host
+	kubeHost := url.Host
+	namespace, endpointsName, hostname, port := kt.parseHostname(kubeHost)
+	ctx := request.Context()
+	if hostname == "" {
+		namespace, endpointsName = kt.splitTraffic(namespace, endpointsName)
+	}
+	subsetSelector, err := getSubsetSelector(request)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := request.Header[SubsetHeader]; ok {
+		// The header is meant for the transport only, and the caller's request is left intact.
+		request2 := *request
+		request2.Header = request.Header.Clone()
+		request2.Header.Del(SubsetHeader)
+		request = &request2
+	}
+	t0 := time.Now()
+	spanCtx, span := kt.options.Tracer.StartSpan(ctx, SpanResolve, endpointsAttributes(namespace, endpointsName))
+	logicalNamespace, logicalEndpointsName := namespace, endpointsName
+	var failedOver bool
+	failoverCacheHit := true
+	if hostname == "" {
+		namespace, endpointsName, failedOver, failoverCacheHit = kt.failOver(spanCtx, namespace, endpointsName)
+	}
+	ipAddresses, terminatingIPAddresses, cacheHit, err := kt.resolveHostname(spanCtx, namespace, endpointsName, hostname)
+	// The endpoints of a failover group got for the first time are not served from the cache.
+	cacheHit = cacheHit && failoverCacheHit
+	var externalName string
+	if errors.Is(err, ErrEndpointsNotFound) {
+		if externalName = kt.endpointsRegistry.GetExternalName(namespace, endpointsName); externalName != "" {
+			err = nil
+		}
+	}
+	resolutionWaitTime := time.Since(t0)
+	spanAttributes := []Attribute{{AttributeAddressCount, len(ipAddresses)}, {AttributeCacheHit, cacheHit}}
+	if externalName != "" {
+		spanAttributes = append(spanAttributes, Attribute{AttributeExternalName, externalName})
+	}
+	if failedOver {
+		spanAttributes = append(spanAttributes, Attribute{AttributeFailoverNamespace, namespace}, Attribute{AttributeFailoverEndpointsName, endpointsName})
+	}
+	endSpan(span, spanAttributes, err)
+	kt.options.MetricsCollector.ObserveHistogram(MetricResolutionDuration, endpointsLabels(logicalNamespace, logicalEndpointsName), resolutionWaitTime.Seconds())
+	partial := hostname != ""
+	if err == nil && subsetSelector != nil && hostname == "" && externalName == "" {
+		var subsetIPAddresses []string
+		subsetIPAddresses, err = kt.endpointsRegistry.FilterIPAddressesByPodLabels(ctx, namespace, endpointsName, ipAddresses, subsetSelector)
+		if err != nil {
+			err = fmt.Errorf("get pod labels; namespace=%q endpointsName=%q: %w", namespace, endpointsName, err)
+		} else if len(subsetIPAddresses) >= 1 {
+			ipAddresses, terminatingIPAddresses = subsetIPAddresses, nil
+			partial = true
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	serviceState := kt.stateRegistry.GetServiceState(namespace, endpointsName)
+	if err := serviceState.Limiter.Acquire(ctx); err != nil {
+		return nil, fmt.Errorf("%w; namespace=%q endpointsName=%q", err, namespace, endpointsName)
+	}
+	if externalName != "" {
+		// ExternalName Services have no endpoints, the external names are resolved through DNS.
+		url.Host = externalName + port
+		if request.Host == kubeHost {
+			// The external server knows nothing about the service.
+			request.Host = url.Host
+		}
+		response, err := kt.transport.RoundTrip(request)
+		if err != nil {
+			serviceState.Limiter.Release()
+			return nil, err
+		}
+		trackBody(response, serviceState.Limiter.Release)
+		return response, nil
+	}
+	serviceState.HedgingBudget.Deposit()
+	serviceState.RetryBudget.Deposit()
+	target := requestTarget{
+		Namespace:     namespace,
+		EndpointsName: endpointsName,
+		Port:          port,
+		IPAddresses:   ipAddresses,
+		ServiceState:  serviceState,
+
+		TerminatingIPAddresses: terminatingIPAddresses,
+		FailedOver:             failedOver,
+		LogicalNamespace:       logicalNamespace,
+		LogicalEndpointsName:   logicalEndpointsName,
+		Partial:                partial,
+
+		CacheHit:           cacheHit,
+		ResolutionWaitTime: resolutionWaitTime,
+	}
+	if hostname == "" {
+		target.SessionAffinityTimeout = kt.endpointsRegistry.GetSessionAffinityTimeout(namespace, endpointsName)
+	}
+	var response *http.Response
+	if hedging, ok := ctx.Value(hedgingKey{}).(Hedging); ok && isReplayable(request) {
+		response, err = kt.doHedgedRequest(request, &target, hedging)
+	} else {
+		response, err = kt.doRetriableRequest(request, &target)
+	}
+	if err != nil {
+		serviceState.Limiter.Release()
+		return nil, err
+	}
+	trackBody(response, serviceState.Limiter.Release)
+	return response, nil
+}
+
+type requestTarget struct {
+	Namespace     string
+	EndpointsName string
+	Port          string
+	IPAddresses   []string
+	ServiceState  *serviceState
+
+	// TerminatingIPAddresses are the ip addresses of the endpoints which are terminating but
+	// still serving, to pick from as a last resort.
+	TerminatingIPAddresses []string
+
+	// FailedOver is true if the target is a standby service of a failover group.
+	FailedOver bool
+
+	// LogicalNamespace and LogicalEndpointsName are of the service requested, which the
+	// target is a standby service of if failed over.
+	LogicalNamespace     string
+	LogicalEndpointsName string
+
+	// Partial is true if the ip addresses are only some of the ones of the service, i.e. of
+	// an endpoint with a hostname or of a subset.
+	Partial bool
+
+	CacheHit           bool
+	ResolutionWaitTime time.Duration
+
+	// SessionAffinityTimeout is the timeout of ClientIP session affinity, or zero if
+	// session affinity is not set.
+	SessionAffinityTimeout time.Duration
+}
+
+// doRequest sends the request to one of the ip addresses of the target other than the
+// excluded one.
+func (kt *kubeTransport) doRequest(request *http.Request, target *requestTarget, excludedIPAddress string) (*http.Response, error) {
+	ipAddress, endpointState, err := kt.pickEndpoint(request.Context(), target, excludedIPAddress)
+	if err != nil {
+		return nil, err
+	}
+	return kt.sendRequest(request, target, ipAddress, endpointState)
+}
+
+func (kt *kubeTransport) sendRequest(request *http.Request, target *requestTarget, ipAddress string, endpointState *endpointState) (*http.Response, error) {
+	ctx := request.Context()
+	kt.traceGotEndpoint(ctx, target, ipAddress)
+	if err := endpointState.Limiter.Acquire(ctx); err != nil {
+		endpointState.Breaker.Cancel()
+		return nil, fmt.Errorf("%w; namespace=%q endpointsName=%q ipAddress=%q", err, target.Namespace, target.EndpointsName, ipAddress)
+	}
+	request.URL.Host = ipAddress + target.Port
+	kt.idleConnectionCloser.BeginRequest(ipAddress)
+	endpointStats := target.ServiceState.Stats.Get(ipAddress)
+	endpointStats.BeginRequest()
+	endRequest := func() {
+		endpointStats.EndRequest()
+		kt.idleConnectionCloser.EndRequest(ipAddress)
+		endpointState.Limiter.Release()
+	}
+	t0 := time.Now()
+	response, err := kt.transport.RoundTrip(request)
+	latency := time.Since(t0)
+	kt.collectEndpointMetrics(target, ipAddress, response, latency)
+	endpointStats.ObserveRequest(latency, (err != nil && ctx.Err() == nil) || (err == nil && response.StatusCode >= 500))
+	if err != nil {
+		if ctx.Err() == nil {
+			endpointState.Breaker.Report(false)
+		} else {
+			endpointState.Breaker.Cancel()
+		}
+		endRequest()
+		return nil, err
+	}
+	target.ServiceState.Latencies.Add(latency)
+	if kt.options.EndpointHeader != "" {
+		if response.Header == nil {
+			response.Header = make(http.Header)
+		}
+		response.Header.Set(kt.options.EndpointHeader, ipAddress+target.Port)
+	}
+	endpointState.Breaker.Report(response.StatusCode < 500)
+	if isRejection(response) {
+		if retryAfter, ok := parseRetryAfter(response, kt.stateRegistry.clock.Now()); ok {
+			endpointState.BackOff(retryAfter)
+		}
+	}
+	trackBody(response, endRequest)
+	return response, nil
+}
+
+func (kt *kubeTransport) collectEndpointMetrics(target *requestTarget, ipAddress string, response *http.Response, latency time.Duration) {
+	labels := []Label{{"namespace", target.Namespace}, {"endpoints_name", target.EndpointsName}, {"ip_address", ipAddress}}
+	kt.options.MetricsCollector.ObserveHistogram(MetricEndpointRequestDuration, labels, latency.Seconds())
+	code := "error"
+	if response != nil {
+		code = strconv.Itoa(response.StatusCode)
+	}
+	kt.options.MetricsCollector.AddCounter(MetricEndpointRequests, append(labels, Label{"code", code}), 1)
+}
+
+// parseHostname parses the hostname in the form of [<hostname>.]<endpoints name>[.<namespace>]
+// [.svc.cluster.local][:<port>] into the namespace, the endpoints name, the hostname of an
+// endpoint (pod), e.g. of a StatefulSet, and the port. The hostname requires the namespace.
+func (kt *kubeTransport) parseHostname(hostname string) (string, string, string, string) {
 	var port string
 	if i := strings.LastIndexByte(hostname, ':'); i >= 0 {
 		port = hostname[i:]
@@ -49,10 +292,31 @@ func (kt *kubeTransport) resolveHostname(request *http.Request) error {
 	if i := strings.LastIndexByte(endpointsName, '.'); i >= 0 {
 		namespace = endpointsName[i+1:]
 		endpointsName = endpointsName[:i]
+	} else {
+		namespace = kt.endpointsRegistry.DefaultNamespace()
 	}
-	ipAddresses, err := kt.endpointsRegistry.GetIPAddresses(request.Context(), namespace, endpointsName)
+	hostname = ""
+	if i := strings.IndexByte(endpointsName, '.'); i >= 0 {
+		hostname = endpointsName[:i]
+		endpointsName = endpointsName[i+1:]
+	}
+	return namespace, endpointsName, hostname, port
+}
+
+// resolveHostname returns the ip addresses of the endpoints to pick from, along with the ones
+// of the endpoints which are terminating as a last resort.
+func (kt *kubeTransport) resolveHostname(ctx context.Context, namespace string, endpointsName string, hostname string) ([]string, []string, bool, error) {
+	ipAddresses, cacheHit, err := kt.endpointsRegistry.LookUpIPAddresses(ctx, namespace, endpointsName)
 	if err != nil {
-		return fmt.Errorf("get ip addresses; namespace=%q endpointsName=%q: %w", namespace, endpointsName, err)
+		return nil, nil, false, kt.newResolutionError(namespace, endpointsName, fmt.Errorf("get ip addresses; namespace=%q endpointsName=%q: %w", namespace, endpointsName, err))
+	}
+	var terminatingIPAddresses []string
+	if ipAddresses != nil {
+		terminatingIPAddresses = kt.endpointsRegistry.GetTerminatingIPAddresses(namespace, endpointsName)
+		if len(ipAddresses) == 0 && len(terminatingIPAddresses) >= 1 {
+			ipAddresses, terminatingIPAddresses = terminatingIPAddresses, nil
+		}
+		ipAddresses = kt.applyNotReadyPolicy(ctx, namespace, endpointsName, ipAddresses)
 	}
 	if len(ipAddresses) == 0 {
 		var err error
@@ -61,11 +325,29 @@ func (kt *kubeTransport) resolveHostname(request *http.Request) error {
 		} else {
 			err = ErrNoIPAddress
 		}
-		return fmt.Errorf("%w; namespace=%q endpointsName=%q", err, namespace, endpointsName)
+		return nil, nil, cacheHit, kt.newResolutionError(namespace, endpointsName, fmt.Errorf("%w; namespace=%q endpointsName=%q", err, namespace, endpointsName))
+	}
+	if hostname != "" {
+		ipAddress, ok := kt.endpointsRegistry.GetHostnameIPAddress(namespace, endpointsName, hostname)
+		if ok && !containsIPAddress(ipAddresses, ipAddress) && kt.notReadyPolicy(ctx) == NotReadyPolicyNever {
+			// The endpoint is not ready.
+			ok = false
+		}
+		if !ok {
+			return nil, nil, cacheHit, kt.newResolutionError(namespace, endpointsName, fmt.Errorf("%w; namespace=%q endpointsName=%q hostname=%q", ErrNoIPAddress, namespace, endpointsName, hostname))
+		}
+		return []string{ipAddress}, nil, cacheHit, nil
+	}
+	return ipAddresses, terminatingIPAddresses, cacheHit, nil
+}
+
+func (kt *kubeTransport) newResolutionError(namespace string, endpointsName string, err error) *ResolutionError {
+	return &ResolutionError{
+		Namespace:     namespace,
+		EndpointsName: endpointsName,
+		History:       kt.endpointsRegistry.History(namespace, endpointsName),
+		err:           err,
 	}
-	ipAddress := kt.pickIPAddress(ipAddresses)
-	url.Host = ipAddress + port
-	return nil
 }
 
 var (
@@ -74,14 +356,11 @@ var (
 
 	// ErrNoIPAddress is returned when there is no ip address of the endpoints.
 	ErrNoIPAddress = errors.New("kubetransport: no ip address")
-)
 
-func (kt *kubeTransport) pickIPAddress(ipAddresses []string) string {
-	x := splitmix64(&kt.seed)
-	i := int(x % uint64(len(ipAddresses)))
-	ipAddress := ipAddresses[i]
-	return ipAddress
-}
+	// ErrOverloaded is returned when a request is rejected due to the limits or
+	// the circuit breakers.
+	ErrOverloaded = errors.New("kubetransport: overloaded")
+)
 
 func splitmix64(seed *uint64) uint64 {
 	z := atomic.AddUint64(seed, 0x9E3779B97F4A7C15)
@@ -89,3 +368,144 @@ func splitmix64(seed *uint64) uint64 {
 	z = (z ^ (z >> 27)) * 0x94D049BB133111EB
 	return z ^ (z >> 31)
 }
+
+// idleConnectionCloser closes the idle connections of a transport once the endpoints (pods)
+// removed at a time are no longer in use by the requests in flight.
+type idleConnectionCloser struct {
+	transport http.RoundTripper
+
+	lock                  sync.Mutex
+	inFlightRequestCounts map[string]int
+	// busyIPAddressBatches are the ip addresses removed at a time which still have requests
+	// in flight, by batch, so that a batch drained is not held up by the other ones.
+	busyIPAddressBatches []map[string]struct{}
+}
+
+func newIdleConnectionCloser(transport http.RoundTripper) *idleConnectionCloser {
+	var icc idleConnectionCloser
+	icc.transport = transport
+	icc.inFlightRequestCounts = make(map[string]int)
+	return &icc
+}
+
+// BeginRequest records a request to the given ip address. It is a no-op on a nil receiver.
+func (icc *idleConnectionCloser) BeginRequest(ipAddress string) {
+	if icc == nil {
+		return
+	}
+	icc.lock.Lock()
+	icc.inFlightRequestCounts[ipAddress]++
+	icc.lock.Unlock()
+}
+
+// EndRequest records the end of a request to the given ip address. It is a no-op on a nil
+// receiver.
+func (icc *idleConnectionCloser) EndRequest(ipAddress string) {
+	if icc == nil {
+		return
+	}
+	icc.lock.Lock()
+	ok := false
+	if n := icc.inFlightRequestCounts[ipAddress] - 1; n == 0 {
+		delete(icc.inFlightRequestCounts, ipAddress)
+		ok = icc.drainIPAddress(ipAddress)
+	} else {
+		icc.inFlightRequestCounts[ipAddress] = n
+	}
+	icc.lock.Unlock()
+	if ok {
+		closeIdleConnections(icc.transport)
+	}
+}
+
+func (icc *idleConnectionCloser) RemoveIPAddresses(ipAddresses []string) {
+	icc.lock.Lock()
+	var busyIPAddresses map[string]struct{}
+	for _, ipAddress := range ipAddresses {
+		if icc.inFlightRequestCounts[ipAddress] >= 1 {
+			if busyIPAddresses == nil {
+				busyIPAddresses = make(map[string]struct{})
+			}
+			busyIPAddresses[ipAddress] = struct{}{}
+		}
+	}
+	if busyIPAddresses != nil {
+		icc.busyIPAddressBatches = append(icc.busyIPAddressBatches, busyIPAddresses)
+	}
+	icc.lock.Unlock()
+	if busyIPAddresses == nil {
+		closeIdleConnections(icc.transport)
+	}
+}
+
+// drainIPAddress forgets the given ip address, which has no request in flight any more, in
+// the batches of the busy ip addresses, and returns true if any batch is drained, so that
+// the connections to the ip addresses of the batch are all idle.
+func (icc *idleConnectionCloser) drainIPAddress(ipAddress string) bool {
+	drained := false
+	busyIPAddressBatches := icc.busyIPAddressBatches[:0]
+	for _, busyIPAddresses := range icc.busyIPAddressBatches {
+		delete(busyIPAddresses, ipAddress)
+		if len(busyIPAddresses) == 0 {
+			drained = true
+			continue
+		}
+		busyIPAddressBatches = append(busyIPAddressBatches, busyIPAddresses)
+	}
+	for i := len(busyIPAddressBatches); i < len(icc.busyIPAddressBatches); i++ {
+		icc.busyIPAddressBatches[i] = nil
+	}
+	icc.busyIPAddressBatches = busyIPAddressBatches
+	return drained
+}
+
+// CloseIdleConnections closes the idle connections of the underlying transport if supported.
+func (kt *kubeTransport) CloseIdleConnections() { closeIdleConnections(kt.transport) }
+
+func closeIdleConnections(transport http.RoundTripper) {
+	type closeIdler interface{ CloseIdleConnections() }
+	if transport, ok := transport.(closeIdler); ok {
+		transport.CloseIdleConnections()
+	}
+}
+
+// trackBody makes the given callback be called once the response body is closed.
+func trackBody(response *http.Response, closeCallback func()) {
+	if response.Body == nil {
+		closeCallback()
+		return
+	}
+	trackedBody := trackedBody{
+		ReadCloser:    response.Body,
+		closeCallback: closeCallback,
+	}
+	if writer, ok := response.Body.(io.Writer); ok {
+		// The body of a 101 Switching Protocols response is writable, for the upgraded
+		// connection, e.g. of WebSocket.
+		response.Body = &trackedReadWriteBody{&trackedBody, writer}
+		return
+	}
+	response.Body = &trackedBody
+}
+
+type trackedBody struct {
+	io.ReadCloser
+
+	closeCallback func()
+	closeOnce     sync.Once
+}
+
+var _ io.ReadCloser = (*trackedBody)(nil)
+
+func (tb *trackedBody) Close() error {
+	err := tb.ReadCloser.Close()
+	tb.closeOnce.Do(tb.closeCallback)
+	return err
+}
+
+type trackedReadWriteBody struct {
+	*trackedBody
+	io.Writer
+}
+
+var _ io.ReadWriteCloser = (*trackedReadWriteBody)(nil)
@@ -1,13 +1,20 @@
 package kubetransport_test
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 	"unsafe"
 
+	"github.com/benbjohnson/clock"
 	. "github.com/go-tk/kubetransport"
 	"github.com/go-tk/kubetransport/internal/k8sclient"
 	mock_k8sclient "github.com/go-tk/kubetransport/internal/k8sclient/mock"
@@ -20,6 +27,7 @@ func TestKubeTransport_RoundTrip(t *testing.T) {
 	type Workspace struct {
 		Init struct {
 			EndpointsRegistry *EndpointsRegistry
+			Options           Options
 			TransportFunc     transportFunc
 			Seed              uint64
 		}
@@ -39,7 +47,9 @@ func TestKubeTransport_RoundTrip(t *testing.T) {
 		Step(0, func(t *testing.T, w *Workspace) {
 			ctrl := gomock.NewController(t)
 			w.MockK8sClient = mock_k8sclient.NewMockK8sClient(ctrl)
-			w.Init.EndpointsRegistry = NewEndpointsRegistry(context.Background(), w.MockK8sClient, 24*time.Hour)
+			w.Init.Options = MakeOptions(nil)
+			w.Init.Options.PanicThreshold = 0
+			w.Init.EndpointsRegistry = NewEndpointsRegistry(context.Background(), w.MockK8sClient, &w.Init.Options, 24*time.Hour)
 			t.Cleanup(w.Init.EndpointsRegistry.Stop)
 			var response http.Response
 			w.Init.TransportFunc = func(*http.Request) (*http.Response, error) { return &response, nil }
@@ -47,7 +57,8 @@ func TestKubeTransport_RoundTrip(t *testing.T) {
 			w.ExpOut.Response = unsafe.Pointer(&response)
 		}).
 		Step(1, func(t *testing.T, w *Workspace) {
-			w.KT = NewKubeTransport(w.Init.EndpointsRegistry, w.Init.TransportFunc, w.Init.Seed)
+			stateRegistry := NewStateRegistry(&w.Init.Options, clock.New())
+			w.KT = NewKubeTransport(w.Init.EndpointsRegistry, stateRegistry, &w.Init.Options, w.Init.TransportFunc, w.Init.Seed)
 		}).
 		Step(2, func(t *testing.T, w *Workspace) {
 			response, err := w.KT.RoundTrip(w.In.Request)
@@ -273,9 +284,1696 @@ func TestKubeTransport_RoundTrip(t *testing.T) {
 				w.ExpOut.Err = context.DeadlineExceeded
 				w.ExpOut.ErrStr = "get ip addresses; namespace=\"test\" endpointsName=\"my-app\": get endpoints; namespace=\"test\" endpointsName=\"my-app\": context deadline exceeded"
 			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.MockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app")).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+						return &k8sclient.Endpoints{
+							Metadata: k8sclient.Metadata{
+								ResourceVersion: "8910",
+							},
+							Subsets: []k8sclient.EndpointSubset{
+								{
+									Addresses: []k8sclient.EndpointAddress{
+										{IP: "1.2.3.4"},
+									},
+								},
+							},
+						}, nil
+					})
+				w.MockK8sClient.EXPECT().WatchEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app"), gomock.Eq("8910"), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName, resourceVersion string, callback k8sclient.WatchEndpointsCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+				w.Init.Options.ServiceLimits = Limits{MaxConcurrentRequests: 1}
+				w.Init.TransportFunc = func(request *http.Request) (*http.Response, error) {
+					return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+				}
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				request, err := http.NewRequest("GET", "kube-https://my-app.test/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+				_, err = w.KT.RoundTrip(request)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+				w.In.Request, err = http.NewRequest("GET", "kube-https://my-app.test/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+				w.ExpOut.Response = nil
+				w.ExpOut.Err = ErrOverloaded
+				w.ExpOut.ErrStr = "kubetransport: overloaded; namespace=\"test\" endpointsName=\"my-app\""
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.MockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app")).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+						return &k8sclient.Endpoints{
+							Metadata: k8sclient.Metadata{
+								ResourceVersion: "8910",
+							},
+							Subsets: []k8sclient.EndpointSubset{
+								{
+									Addresses: []k8sclient.EndpointAddress{
+										{IP: "1.2.3.4"},
+										{IP: "2.3.4.5"},
+									},
+								},
+							},
+						}, nil
+					})
+				w.MockK8sClient.EXPECT().WatchEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app"), gomock.Eq("8910"), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName, resourceVersion string, callback k8sclient.WatchEndpointsCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+				w.Init.Options.CircuitBreaker = CircuitBreaker{ConsecutiveFailures: 1, OpenDuration: time.Hour}
+				var response http.Response
+				var hosts []string
+				w.Init.TransportFunc = func(request *http.Request) (*http.Response, error) {
+					hosts = append(hosts, request.URL.Host)
+					switch len(hosts) {
+					case 1:
+						return &http.Response{StatusCode: http.StatusServiceUnavailable}, nil
+					default:
+						assert.NotEqual(t, hosts[0], request.URL.Host)
+						return &response, nil
+					}
+				}
+				w.ExpOut.Response = unsafe.Pointer(&response)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				for i := 0; i < 2; i++ {
+					request, err := http.NewRequest("GET", "kube-https://my-app.test/aa/bb", nil)
+					if !assert.NoError(t, err) {
+						t.FailNow()
+					}
+					_, err = w.KT.RoundTrip(request)
+					if !assert.NoError(t, err) {
+						t.FailNow()
+					}
+				}
+				var err error
+				w.In.Request, err = http.NewRequest("GET", "kube-https://my-app.test/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.MockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app")).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+						return &k8sclient.Endpoints{
+							Metadata: k8sclient.Metadata{
+								ResourceVersion: "8910",
+							},
+							Subsets: []k8sclient.EndpointSubset{
+								{
+									Addresses: []k8sclient.EndpointAddress{
+										{IP: "1.2.3.4"},
+										{IP: "2.3.4.5"},
+									},
+								},
+							},
+						}, nil
+					})
+				w.MockK8sClient.EXPECT().WatchEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app"), gomock.Eq("8910"), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName, resourceVersion string, callback k8sclient.WatchEndpointsCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+				w.Init.Options.HedgingBudget = 1
+				var response http.Response
+				slowRequestDone := make(chan struct{})
+				var lock sync.Mutex
+				var hosts []string
+				w.Init.TransportFunc = func(request *http.Request) (*http.Response, error) {
+					lock.Lock()
+					hosts = append(hosts, request.URL.Host)
+					n := len(hosts)
+					lock.Unlock()
+					if n == 1 {
+						<-request.Context().Done()
+						close(slowRequestDone)
+						return nil, request.Context().Err()
+					}
+					return &response, nil
+				}
+				w.ExpOut.Response = unsafe.Pointer(&response)
+				t.Cleanup(func() {
+					<-slowRequestDone
+					// The hedged request is sent to another endpoint.
+					if assert.Len(t, hosts, 2) {
+						assert.NotEqual(t, hosts[0], hosts[1])
+					}
+				})
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				ctx := WithHedging(context.Background(), Hedging{Delay: 10 * time.Millisecond})
+				var err error
+				w.In.Request, err = http.NewRequestWithContext(ctx, "GET", "kube-https://my-app.test/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.MockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app")).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+						return &k8sclient.Endpoints{
+							Metadata: k8sclient.Metadata{
+								ResourceVersion: "8910",
+							},
+							Subsets: []k8sclient.EndpointSubset{
+								{
+									Addresses: []k8sclient.EndpointAddress{
+										{IP: "1.2.3.4"},
+										{IP: "2.3.4.5"},
+									},
+								},
+							},
+						}, nil
+					})
+				w.MockK8sClient.EXPECT().WatchEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app"), gomock.Eq("8910"), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName, resourceVersion string, callback k8sclient.WatchEndpointsCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+				w.Init.Options.HedgingBudget = 0
+				var response http.Response
+				var n int32
+				w.Init.TransportFunc = func(request *http.Request) (*http.Response, error) {
+					atomic.AddInt32(&n, 1)
+					time.Sleep(50 * time.Millisecond)
+					return &response, nil
+				}
+				w.ExpOut.Response = unsafe.Pointer(&response)
+				t.Cleanup(func() {
+					// No hedged request is sent without a hedging budget.
+					assert.Equal(t, int32(1), atomic.LoadInt32(&n))
+				})
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				ctx := WithHedging(context.Background(), Hedging{Delay: 10 * time.Millisecond})
+				var err error
+				w.In.Request, err = http.NewRequestWithContext(ctx, "GET", "kube-https://my-app.test/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.MockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app")).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+						return &k8sclient.Endpoints{
+							Metadata: k8sclient.Metadata{
+								ResourceVersion: "8910",
+							},
+							Subsets: []k8sclient.EndpointSubset{
+								{
+									Addresses: []k8sclient.EndpointAddress{
+										{IP: "1.2.3.4"},
+										{IP: "2.3.4.5"},
+									},
+								},
+							},
+						}, nil
+					})
+				w.MockK8sClient.EXPECT().WatchEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app"), gomock.Eq("8910"), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName, resourceVersion string, callback k8sclient.WatchEndpointsCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+				w.Init.Options.MaxRetries = 1
+				w.Init.Options.RetryBudget = 1
+				var response http.Response
+				var hosts []string
+				w.Init.TransportFunc = func(request *http.Request) (*http.Response, error) {
+					hosts = append(hosts, request.URL.Host)
+					if request.URL.Host == hosts[0] {
+						if !assert.Len(t, hosts, 1) {
+							t.FailNow()
+						}
+						return &http.Response{
+							StatusCode: http.StatusServiceUnavailable,
+							Header:     http.Header{"Retry-After": {"60"}},
+							Body:       http.NoBody,
+						}, nil
+					}
+					return &response, nil
+				}
+				w.ExpOut.Response = unsafe.Pointer(&response)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				for i := 0; i < 2; i++ {
+					request, err := http.NewRequest("GET", "kube-https://my-app.test/aa/bb", nil)
+					if !assert.NoError(t, err) {
+						t.FailNow()
+					}
+					_, err = w.KT.RoundTrip(request)
+					if !assert.NoError(t, err) {
+						t.FailNow()
+					}
+				}
+				var err error
+				w.In.Request, err = http.NewRequest("GET", "kube-https://my-app.test/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.MockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app")).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+						return &k8sclient.Endpoints{
+							Metadata: k8sclient.Metadata{
+								ResourceVersion: "8910",
+							},
+							Subsets: []k8sclient.EndpointSubset{
+								{
+									Addresses: []k8sclient.EndpointAddress{
+										{IP: "1.2.3.4"},
+										{IP: "2.3.4.5"},
+									},
+								},
+							},
+						}, nil
+					})
+				w.MockK8sClient.EXPECT().WatchEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app"), gomock.Eq("8910"), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName, resourceVersion string, callback k8sclient.WatchEndpointsCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+				w.Init.Options.CircuitBreaker = CircuitBreaker{ConsecutiveFailures: 1, OpenDuration: time.Hour}
+				w.Init.Options.PanicThreshold = 50
+				var panicModes []bool
+				w.Init.Options.PanicModeHook = func(namespace, endpointsName string, panicking bool) {
+					assert.Equal(t, "test", namespace)
+					assert.Equal(t, "my-app", endpointsName)
+					panicModes = append(panicModes, panicking)
+				}
+				var response http.Response
+				var n int
+				w.Init.TransportFunc = func(request *http.Request) (*http.Response, error) {
+					n++
+					if n <= 2 {
+						return &http.Response{StatusCode: http.StatusInternalServerError}, nil
+					}
+					assert.Equal(t, []bool{true}, panicModes)
+					return &response, nil
+				}
+				w.ExpOut.Response = unsafe.Pointer(&response)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				for i := 0; i < 2; i++ {
+					request, err := http.NewRequest("GET", "kube-https://my-app.test/aa/bb", nil)
+					if !assert.NoError(t, err) {
+						t.FailNow()
+					}
+					_, err = w.KT.RoundTrip(request)
+					if !assert.NoError(t, err) {
+						t.FailNow()
+					}
+				}
+				var err error
+				w.In.Request, err = http.NewRequest("GET", "kube-https://my-app.test/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.MockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app")).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+						return &k8sclient.Endpoints{
+							Metadata: k8sclient.Metadata{
+								ResourceVersion: "8910",
+							},
+							Subsets: []k8sclient.EndpointSubset{
+								{
+									Addresses: []k8sclient.EndpointAddress{
+										{IP: "1.2.3.4"},
+									},
+								},
+							},
+						}, nil
+					})
+				w.MockK8sClient.EXPECT().WatchEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app"), gomock.Eq("8910"), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName, resourceVersion string, callback k8sclient.WatchEndpointsCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+				w.Init.Options.EndpointHeader = EndpointHeader
+				var response http.Response
+				w.Init.TransportFunc = func(request *http.Request) (*http.Response, error) {
+					return &response, nil
+				}
+				w.ExpOut.Response = unsafe.Pointer(&response)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				var resolutionInfos []ResolutionInfo
+				trace := ResolutionTrace{
+					GotEndpoint: func(resolutionInfo ResolutionInfo) {
+						assert.True(t, resolutionInfo.WaitTime > 0)
+						resolutionInfo.WaitTime = 0
+						resolutionInfos = append(resolutionInfos, resolutionInfo)
+					},
+				}
+				ctx := WithResolutionTrace(context.Background(), &trace)
+				request, err := http.NewRequestWithContext(ctx, "GET", "kube-http://my-app.test:8080/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+				_, err = w.KT.RoundTrip(request)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+				w.In.Request, err = http.NewRequestWithContext(ctx, "GET", "kube-http://my-app.test:8080/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+				t.Cleanup(func() {
+					assert.Equal(t, []ResolutionInfo{
+						{Namespace: "test", EndpointsName: "my-app", IPAddress: "1.2.3.4", CacheHit: false},
+						{Namespace: "test", EndpointsName: "my-app", IPAddress: "1.2.3.4", CacheHit: true},
+					}, resolutionInfos)
+				})
+			}).
+			Step(3.5, func(t *testing.T, w *Workspace) {
+				response := (*http.Response)(w.ActOut.Response)
+				assert.Equal(t, "1.2.3.4:8080", response.Header.Get(EndpointHeader))
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.MockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app")).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+						return &k8sclient.Endpoints{
+							Metadata: k8sclient.Metadata{
+								ResourceVersion: "8910",
+							},
+							Subsets: []k8sclient.EndpointSubset{
+								{
+									Addresses: []k8sclient.EndpointAddress{
+										{IP: "1.2.3.4"},
+									},
+								},
+							},
+						}, nil
+					})
+				w.MockK8sClient.EXPECT().WatchEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app"), gomock.Eq("8910"), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName, resourceVersion string, callback k8sclient.WatchEndpointsCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+				w.MockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("other-app")).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+						return nil, nil
+					})
+				w.MockK8sClient.EXPECT().WatchEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("other-app"), gomock.Eq(""), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName, resourceVersion string, callback k8sclient.WatchEndpointsCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+				tracer := new(recordingTracer)
+				w.Init.Options.Tracer = tracer
+				var response http.Response
+				w.Init.TransportFunc = func(request *http.Request) (*http.Response, error) {
+					return &response, nil
+				}
+				w.ExpOut.Response = unsafe.Pointer(&response)
+				t.Cleanup(func() {
+					assert.Equal(t, []string{
+						"start kubetransport.resolve [kubetransport.namespace=test kubetransport.endpoints_name=other-app]",
+						"end kubetransport.resolve [kubetransport.address_count=0 kubetransport.cache_hit=false kubetransport.error_type=endpoints_not_found]",
+						"start kubetransport.resolve [kubetransport.namespace=test kubetransport.endpoints_name=my-app]",
+						"end kubetransport.resolve [kubetransport.address_count=1 kubetransport.cache_hit=false]",
+						"start kubetransport.pick [kubetransport.namespace=test kubetransport.endpoints_name=my-app kubetransport.address_count=1]",
+						"end kubetransport.pick [kubetransport.ip_address=1.2.3.4]",
+					}, tracer.Records())
+				})
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				request, err := http.NewRequest("GET", "kube-http://other-app.test/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+				_, err = w.KT.RoundTrip(request)
+				if !assert.ErrorIs(t, err, ErrEndpointsNotFound) {
+					t.FailNow()
+				}
+				var resolutionError *ResolutionError
+				if assert.ErrorAs(t, err, &resolutionError) {
+					assert.Equal(t, "kubetransport: endpoints not found; namespace=\"test\" endpointsName=\"other-app\"", resolutionError.Error())
+					if assert.Len(t, resolutionError.History, 1) {
+						assert.Equal(t, "GET", resolutionError.History[0].EventType)
+					}
+				}
+				w.In.Request, err = http.NewRequest("GET", "kube-http://my-app.test/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.MockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app")).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+						return &k8sclient.Endpoints{
+							Metadata: k8sclient.Metadata{
+								ResourceVersion: "8910",
+							},
+							Subsets: []k8sclient.EndpointSubset{
+								{
+									Addresses: []k8sclient.EndpointAddress{
+										{IP: "1.2.3.4", NodeName: "node-1"},
+										{IP: "2.3.4.5", NodeName: "node-2"},
+										{IP: "3.4.5.6", NodeName: "node-3"},
+									},
+								},
+							},
+						}, nil
+					})
+				w.MockK8sClient.EXPECT().WatchEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app"), gomock.Eq("8910"), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName, resourceVersion string, callback k8sclient.WatchEndpointsCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+				for nodeName, zone := range map[string]string{"node-1": "zone-a", "node-2": "zone-b", "node-3": "zone-b"} {
+					node := k8sclient.Node{Metadata: k8sclient.Metadata{Labels: map[string]string{"topology.kubernetes.io/zone": zone}}}
+					w.MockK8sClient.EXPECT().GetNode(gomock.Any(), gomock.Eq(nodeName)).Return(&node, nil)
+				}
+				WithZoneAwareRouting(ZoneAwareRouting{Zone: "zone-a"})(&w.Init.Options)
+				var response http.Response
+				w.Init.TransportFunc = func(request *http.Request) (*http.Response, error) {
+					assert.Equal(t, "http://1.2.3.4/aa/bb", request.URL.String())
+					return &response, nil
+				}
+				w.ExpOut.Response = unsafe.Pointer(&response)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				_, err := w.Init.EndpointsRegistry.GetIPAddresses(context.Background(), "test", "my-app")
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+				assert.Eventually(t, func() bool {
+					return w.Init.EndpointsRegistry.GetZone("test", "my-app", "1.2.3.4") == "zone-a" &&
+						w.Init.EndpointsRegistry.GetZone("test", "my-app", "2.3.4.5") == "zone-b" &&
+						w.Init.EndpointsRegistry.GetZone("test", "my-app", "3.4.5.6") == "zone-b"
+				}, time.Second, 10*time.Millisecond)
+				for i := 0; i < 10; i++ {
+					request, err := http.NewRequest("GET", "kube-http://my-app.test/aa/bb", nil)
+					if !assert.NoError(t, err) {
+						t.FailNow()
+					}
+					_, err = w.KT.RoundTrip(request)
+					if !assert.NoError(t, err) {
+						t.FailNow()
+					}
+				}
+				w.In.Request, err = http.NewRequest("GET", "kube-http://my-app.test/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.MockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app")).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+						return &k8sclient.Endpoints{
+							Metadata: k8sclient.Metadata{
+								ResourceVersion: "8910",
+							},
+							Subsets: []k8sclient.EndpointSubset{
+								{
+									Addresses: []k8sclient.EndpointAddress{
+										{IP: "1.2.3.4", NodeName: "node-1"},
+										{IP: "2.3.4.5", NodeName: "node-2"},
+										{IP: "3.4.5.6", NodeName: "node-3"},
+									},
+								},
+							},
+						}, nil
+					})
+				w.MockK8sClient.EXPECT().WatchEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app"), gomock.Eq("8910"), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName, resourceVersion string, callback k8sclient.WatchEndpointsCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+				w.Init.Options.NodeName = "node-2"
+				WithNodeLocalPolicy(NodeLocalPolicyPreferLocal)(&w.Init.Options)
+				var response http.Response
+				w.Init.TransportFunc = func(request *http.Request) (*http.Response, error) {
+					assert.Equal(t, "http://2.3.4.5/aa/bb", request.URL.String())
+					return &response, nil
+				}
+				w.ExpOut.Response = unsafe.Pointer(&response)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				for i := 0; i < 10; i++ {
+					request, err := http.NewRequest("GET", "kube-http://my-app.test/aa/bb", nil)
+					if !assert.NoError(t, err) {
+						t.FailNow()
+					}
+					_, err = w.KT.RoundTrip(request)
+					if !assert.NoError(t, err) {
+						t.FailNow()
+					}
+				}
+				ctx := WithRequestNodeLocalPolicy(context.Background(), NodeLocalPolicyLocalOnly)
+				var err error
+				w.In.Request, err = http.NewRequestWithContext(ctx, "GET", "kube-http://my-app.test/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.MockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app")).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+						return &k8sclient.Endpoints{
+							Metadata: k8sclient.Metadata{
+								ResourceVersion: "8910",
+							},
+							Subsets: []k8sclient.EndpointSubset{
+								{
+									Addresses: []k8sclient.EndpointAddress{
+										{IP: "1.2.3.4", NodeName: "node-1"},
+									},
+								},
+							},
+						}, nil
+					})
+				w.MockK8sClient.EXPECT().WatchEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app"), gomock.Eq("8910"), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName, resourceVersion string, callback k8sclient.WatchEndpointsCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+				w.Init.Options.NodeName = "node-2"
+				WithNodeLocalPolicy(NodeLocalPolicyLocalOnly)(&w.Init.Options)
+				w.Init.TransportFunc = func(request *http.Request) (*http.Response, error) {
+					t.Fatal()
+					return nil, nil
+				}
+				w.ExpOut.Response = nil
+				w.ExpOut.Err = ErrNoIPAddress
+				w.ExpOut.ErrStr = "kubetransport: no ip address on node; namespace=\"test\" endpointsName=\"my-app\" nodeName=\"node-2\""
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				var err error
+				w.In.Request, err = http.NewRequest("GET", "kube-http://my-app.test/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				gettingEndpoints := make(chan struct{})
+				w.MockK8sClient.EXPECT().GetService(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app")).
+					DoAndReturn(func(ctx context.Context, namespace, serviceName string) (*k8sclient.Service, error) {
+						// The Service is got concurrently with the endpoints.
+						select {
+						case <-gettingEndpoints:
+						case <-time.After(5 * time.Second):
+							t.Error("endpoints not got concurrently")
+						}
+						return &k8sclient.Service{
+							Metadata: k8sclient.Metadata{
+								ResourceVersion: "8900",
+							},
+							Spec: k8sclient.ServiceSpec{
+								SessionAffinity: k8sclient.SessionAffinityClientIP,
+							},
+						}, nil
+					})
+				serviceCallbacks := make(chan k8sclient.WatchServiceCallback, 1)
+				w.MockK8sClient.EXPECT().WatchService(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app"), gomock.Eq("8900"), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, serviceName, resourceVersion string, callback k8sclient.WatchServiceCallback) error {
+						serviceCallbacks <- callback
+						<-ctx.Done()
+						return ctx.Err()
+					})
+				t.Cleanup(func() {
+					assert.Equal(t, 3*time.Hour, w.Init.EndpointsRegistry.GetSessionAffinityTimeout("test", "my-app"))
+					callback := <-serviceCallbacks
+					callback(k8sclient.EventModified, &k8sclient.Service{
+						Metadata: k8sclient.Metadata{
+							ResourceVersion: "8920",
+						},
+					})
+					assert.Equal(t, time.Duration(0), w.Init.EndpointsRegistry.GetSessionAffinityTimeout("test", "my-app"))
+				})
+				w.MockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app")).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+						close(gettingEndpoints)
+						return &k8sclient.Endpoints{
+							Metadata: k8sclient.Metadata{
+								ResourceVersion: "8910",
+							},
+							Subsets: []k8sclient.EndpointSubset{
+								{
+									Addresses: []k8sclient.EndpointAddress{
+										{IP: "1.2.3.4"},
+										{IP: "2.3.4.5"},
+										{IP: "3.4.5.6"},
+									},
+								},
+							},
+						}, nil
+					})
+				w.MockK8sClient.EXPECT().WatchEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app"), gomock.Eq("8910"), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName, resourceVersion string, callback k8sclient.WatchEndpointsCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+				WithServiceLookup()(&w.Init.Options)
+				var response http.Response
+				hosts := make(map[string]struct{})
+				w.Init.TransportFunc = func(request *http.Request) (*http.Response, error) {
+					hosts[request.URL.Host] = struct{}{}
+					return &response, nil
+				}
+				w.ExpOut.Response = unsafe.Pointer(&response)
+				t.Cleanup(func() {
+					assert.Len(t, hosts, 1)
+				})
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				for i := 0; i < 10; i++ {
+					request, err := http.NewRequest("GET", "kube-http://my-app.test/aa/bb", nil)
+					if !assert.NoError(t, err) {
+						t.FailNow()
+					}
+					_, err = w.KT.RoundTrip(request)
+					if !assert.NoError(t, err) {
+						t.FailNow()
+					}
+				}
+				var err error
+				w.In.Request, err = http.NewRequest("GET", "kube-http://my-app.test/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.MockK8sClient.EXPECT().GetService(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app")).
+					DoAndReturn(func(ctx context.Context, namespace, serviceName string) (*k8sclient.Service, error) {
+						return &k8sclient.Service{
+							Spec: k8sclient.ServiceSpec{
+								Type:         k8sclient.ServiceTypeExternalName,
+								ExternalName: "example.com",
+							},
+						}, nil
+					})
+				w.MockK8sClient.EXPECT().WatchService(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app"), gomock.Eq(""), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, serviceName, resourceVersion string, callback k8sclient.WatchServiceCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+				w.MockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app")).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+						return nil, nil
+					})
+				w.MockK8sClient.EXPECT().WatchEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app"), gomock.Eq(""), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName, resourceVersion string, callback k8sclient.WatchEndpointsCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+				WithServiceLookup()(&w.Init.Options)
+				var response http.Response
+				w.Init.TransportFunc = func(request *http.Request) (*http.Response, error) {
+					assert.Equal(t, "https://example.com:8443/aa/bb", request.URL.String())
+					assert.Equal(t, "example.com:8443", request.Host)
+					return &response, nil
+				}
+				w.ExpOut.Response = unsafe.Pointer(&response)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				var err error
+				w.In.Request, err = http.NewRequest("GET", "kube-https://my-app.test:8443/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.MockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("db")).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+						return &k8sclient.Endpoints{
+							Metadata: k8sclient.Metadata{
+								ResourceVersion: "8910",
+							},
+							Subsets: []k8sclient.EndpointSubset{
+								{
+									Addresses: []k8sclient.EndpointAddress{
+										{IP: "1.2.3.4", Hostname: "db-0"},
+										{IP: "2.3.4.5", TargetRef: &k8sclient.ObjectReference{Kind: "Pod", Namespace: "test", Name: "db-1"}},
+										{IP: "3.4.5.6", Hostname: "db-2"},
+									},
+									NotReadyAddresses: []k8sclient.EndpointAddress{
+										{IP: "4.5.6.7", Hostname: "db-3"},
+									},
+								},
+							},
+						}, nil
+					})
+				w.MockK8sClient.EXPECT().WatchEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("db"), gomock.Eq("8910"), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName, resourceVersion string, callback k8sclient.WatchEndpointsCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+				var response http.Response
+				var urls []string
+				w.Init.TransportFunc = func(request *http.Request) (*http.Response, error) {
+					urls = append(urls, request.URL.String())
+					return &response, nil
+				}
+				w.ExpOut.Response = unsafe.Pointer(&response)
+				t.Cleanup(func() {
+					assert.Equal(t, []string{
+						"http://1.2.3.4:5432/aa/bb",
+						"http://4.5.6.7:5432/aa/bb",
+						"http://2.3.4.5:5432/aa/bb",
+					}, urls)
+				})
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				request, err := http.NewRequest("GET", "kube-http://db-0.db.test:5432/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+				_, err = w.KT.RoundTrip(request)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+				request, err = http.NewRequest("GET", "kube-http://db-9.db.test.svc.cluster.local:5432/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+				_, err = w.KT.RoundTrip(request)
+				if assert.ErrorIs(t, err, ErrNoIPAddress) {
+					assert.Equal(t, "kubetransport: no ip address; namespace=\"test\" endpointsName=\"db\" hostname=\"db-9\"", err.Error())
+				}
+				request, err = http.NewRequest("GET", "kube-http://db-3.db.test:5432/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+				_, err = w.KT.RoundTrip(request)
+				assert.ErrorIs(t, err, ErrNoIPAddress)
+				request, err = http.NewRequest("GET", "kube-http://db-3.db.test:5432/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+				request = request.WithContext(WithRequestNotReadyPolicy(request.Context(), NotReadyPolicyFallback))
+				_, err = w.KT.RoundTrip(request)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+				w.In.Request, err = http.NewRequest("GET", "kube-http://db-1.db.test:5432/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.MockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app")).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+						return &k8sclient.Endpoints{
+							Metadata: k8sclient.Metadata{
+								ResourceVersion: "8910",
+							},
+							Subsets: []k8sclient.EndpointSubset{
+								{
+									NotReadyAddresses: []k8sclient.EndpointAddress{
+										{IP: "1.2.3.4"},
+									},
+								},
+							},
+						}, nil
+					})
+				w.MockK8sClient.EXPECT().WatchEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app"), gomock.Eq("8910"), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName, resourceVersion string, callback k8sclient.WatchEndpointsCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+				var response http.Response
+				w.Init.TransportFunc = func(request *http.Request) (*http.Response, error) {
+					assert.Equal(t, "http://1.2.3.4/aa/bb", request.URL.String())
+					return &response, nil
+				}
+				w.ExpOut.Response = unsafe.Pointer(&response)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				request, err := http.NewRequest("GET", "kube-http://my-app.test/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+				_, err = w.KT.RoundTrip(request)
+				assert.ErrorIs(t, err, ErrNoIPAddress)
+				ctx := WithRequestNotReadyPolicy(context.Background(), NotReadyPolicyFallback)
+				w.In.Request, err = http.NewRequestWithContext(ctx, "GET", "kube-http://my-app.test/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				true_, false_ := true, false
+				w.MockK8sClient.EXPECT().ListEndpointSlices(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app")).
+					DoAndReturn(func(ctx context.Context, namespace, serviceName string) (*k8sclient.EndpointSliceList, error) {
+						return &k8sclient.EndpointSliceList{
+							Metadata: k8sclient.Metadata{
+								ResourceVersion: "8910",
+							},
+							Items: []k8sclient.EndpointSlice{
+								{
+									Metadata: k8sclient.Metadata{Name: "my-app-abcde"},
+									Endpoints: []k8sclient.Endpoint{
+										{Addresses: []string{"1.2.3.4"}, Conditions: k8sclient.EndpointConditions{Ready: &true_}},
+										{Addresses: []string{"2.3.4.5"}, Conditions: k8sclient.EndpointConditions{Ready: &false_, Serving: &true_, Terminating: &true_}},
+									},
+								},
+							},
+						}, nil
+					})
+				w.MockK8sClient.EXPECT().WatchEndpointSlices(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app"), gomock.Eq("8910"), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, serviceName, resourceVersion string, callback k8sclient.WatchEndpointSlicesCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+				w.MockK8sClient.EXPECT().ListEndpointSlices(gomock.Any(), gomock.Eq("test"), gomock.Eq("other-app")).
+					DoAndReturn(func(ctx context.Context, namespace, serviceName string) (*k8sclient.EndpointSliceList, error) {
+						return &k8sclient.EndpointSliceList{
+							Metadata: k8sclient.Metadata{
+								ResourceVersion: "8920",
+							},
+							Items: []k8sclient.EndpointSlice{
+								{
+									Metadata: k8sclient.Metadata{Name: "other-app-abcde"},
+									Endpoints: []k8sclient.Endpoint{
+										{Addresses: []string{"3.4.5.6"}, Conditions: k8sclient.EndpointConditions{Ready: &false_, Serving: &true_, Terminating: &true_}},
+										{Addresses: []string{"4.5.6.7"}, Conditions: k8sclient.EndpointConditions{Ready: &false_, Serving: &false_, Terminating: &true_}},
+									},
+								},
+							},
+						}, nil
+					})
+				w.MockK8sClient.EXPECT().WatchEndpointSlices(gomock.Any(), gomock.Eq("test"), gomock.Eq("other-app"), gomock.Eq("8920"), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, serviceName, resourceVersion string, callback k8sclient.WatchEndpointSlicesCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+				WithEndpointSlices()(&w.Init.Options)
+				var response http.Response
+				var urls []string
+				w.Init.TransportFunc = func(request *http.Request) (*http.Response, error) {
+					urls = append(urls, request.URL.String())
+					return &response, nil
+				}
+				w.ExpOut.Response = unsafe.Pointer(&response)
+				t.Cleanup(func() {
+					assert.Equal(t, []string{
+						"http://1.2.3.4/aa/bb",
+						"http://1.2.3.4/aa/bb",
+						"http://1.2.3.4/aa/bb",
+						"http://3.4.5.6/aa/bb",
+					}, urls)
+				})
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				for i := 0; i < 3; i++ {
+					request, err := http.NewRequest("GET", "kube-http://my-app.test/aa/bb", nil)
+					if !assert.NoError(t, err) {
+						t.FailNow()
+					}
+					_, err = w.KT.RoundTrip(request)
+					if !assert.NoError(t, err) {
+						t.FailNow()
+					}
+				}
+				var err error
+				w.In.Request, err = http.NewRequest("GET", "kube-http://other-app.test/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.MockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app")).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+						return &k8sclient.Endpoints{
+							Metadata: k8sclient.Metadata{
+								ResourceVersion: "8910",
+							},
+							Subsets: []k8sclient.EndpointSubset{
+								{
+									Addresses: []k8sclient.EndpointAddress{
+										{IP: "1.2.3.4", TargetRef: &k8sclient.ObjectReference{Kind: "Pod", Name: "my-app-1"}},
+										{IP: "2.3.4.5", TargetRef: &k8sclient.ObjectReference{Kind: "Pod", Name: "my-app-2"}},
+										{IP: "3.4.5.6", TargetRef: &k8sclient.ObjectReference{Kind: "Pod", Name: "my-app-3"}},
+									},
+								},
+							},
+						}, nil
+					})
+				w.MockK8sClient.EXPECT().WatchEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app"), gomock.Eq("8910"), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName, resourceVersion string, callback k8sclient.WatchEndpointsCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+				w.MockK8sClient.EXPECT().GetService(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app")).
+					Return(&k8sclient.Service{Spec: k8sclient.ServiceSpec{Selector: map[string]string{"app": "my-app"}}}, nil)
+				w.MockK8sClient.EXPECT().ListPods(gomock.Any(), gomock.Eq("test"), gomock.Eq("app=my-app")).
+					DoAndReturn(func(ctx context.Context, namespace, labelSelector string) (*k8sclient.PodList, error) {
+						podList := k8sclient.PodList{Metadata: k8sclient.Metadata{ResourceVersion: "8920"}}
+						for _, podName := range []string{"my-app-1", "my-app-2", "my-app-3", "my-app-4"} {
+							version := "v1"
+							if podName == "my-app-2" {
+								version = "v2"
+							}
+							podList.Items = append(podList.Items, k8sclient.Pod{
+								Metadata: k8sclient.Metadata{Name: podName, Labels: map[string]string{"app": "my-app", "version": version}},
+							})
+						}
+						return &podList, nil
+					})
+				w.MockK8sClient.EXPECT().WatchPods(gomock.Any(), gomock.Eq("test"), gomock.Eq("app=my-app"), gomock.Eq("8920"), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, labelSelector, resourceVersion string, callback k8sclient.WatchPodsCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+				var response http.Response
+				var urls []string
+				w.Init.TransportFunc = func(request *http.Request) (*http.Response, error) {
+					assert.Empty(t, request.Header.Get(SubsetHeader))
+					urls = append(urls, request.URL.String())
+					return &response, nil
+				}
+				w.ExpOut.Response = unsafe.Pointer(&response)
+				t.Cleanup(func() {
+					assert.Equal(t, []string{
+						"http://2.3.4.5/aa/bb",
+						"http://2.3.4.5/aa/bb",
+						"http://2.3.4.5/aa/bb",
+						"http://2.3.4.5/aa/bb",
+					}, urls)
+				})
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				for i := 0; i < 3; i++ {
+					ctx := WithSubset(context.Background(), map[string]string{"version": "v2"})
+					request, err := http.NewRequestWithContext(ctx, "GET", "kube-http://my-app.test/aa/bb", nil)
+					if !assert.NoError(t, err) {
+						t.FailNow()
+					}
+					_, err = w.KT.RoundTrip(request)
+					if !assert.NoError(t, err) {
+						t.FailNow()
+					}
+				}
+				request, err := http.NewRequest("GET", "kube-http://my-app.test/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+				request.Header.Set(SubsetHeader, "version")
+				_, err = w.KT.RoundTrip(request)
+				assert.EqualError(t, err, "kubetransport: invalid subset header; value=\"version\"")
+				w.In.Request, err = http.NewRequest("GET", "kube-http://my-app.test/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+				w.In.Request.Header.Set(SubsetHeader, "app=my-app, version=v2")
+				t.Cleanup(func() {
+					assert.Equal(t, "app=my-app, version=v2", w.In.Request.Header.Get(SubsetHeader))
+				})
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.MockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app")).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+						return &k8sclient.Endpoints{
+							Metadata: k8sclient.Metadata{
+								ResourceVersion: "8910",
+							},
+							Subsets: []k8sclient.EndpointSubset{
+								{
+									Addresses: []k8sclient.EndpointAddress{
+										{IP: "1.2.3.4", TargetRef: &k8sclient.ObjectReference{Kind: "Pod", Name: "my-app-1"}},
+										{IP: "2.3.4.5", TargetRef: &k8sclient.ObjectReference{Kind: "Pod", Name: "my-app-2"}},
+										{IP: "3.4.5.6", TargetRef: &k8sclient.ObjectReference{Kind: "Pod", Name: "my-app-3"}},
+									},
+								},
+							},
+						}, nil
+					})
+				w.MockK8sClient.EXPECT().WatchEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app"), gomock.Eq("8910"), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName, resourceVersion string, callback k8sclient.WatchEndpointsCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+				w.MockK8sClient.EXPECT().GetService(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app")).
+					Return(&k8sclient.Service{
+						Metadata: k8sclient.Metadata{
+							ResourceVersion: "8900",
+						},
+						Spec: k8sclient.ServiceSpec{
+							Selector:        map[string]string{"app": "my-app"},
+							SessionAffinity: k8sclient.SessionAffinityClientIP,
+						},
+					}, nil).Times(2)
+				w.MockK8sClient.EXPECT().WatchService(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app"), gomock.Eq("8900"), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, serviceName, resourceVersion string, callback k8sclient.WatchServiceCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+				w.MockK8sClient.EXPECT().ListPods(gomock.Any(), gomock.Eq("test"), gomock.Eq("app=my-app")).
+					DoAndReturn(func(ctx context.Context, namespace, labelSelector string) (*k8sclient.PodList, error) {
+						podList := k8sclient.PodList{Metadata: k8sclient.Metadata{ResourceVersion: "8920"}}
+						for _, podName := range []string{"my-app-1", "my-app-2", "my-app-3"} {
+							podList.Items = append(podList.Items, k8sclient.Pod{
+								Metadata: k8sclient.Metadata{Name: podName, Labels: map[string]string{"app": "my-app", "pod": podName}},
+							})
+						}
+						return &podList, nil
+					})
+				w.MockK8sClient.EXPECT().WatchPods(gomock.Any(), gomock.Eq("test"), gomock.Eq("app=my-app"), gomock.Eq("8920"), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, labelSelector, resourceVersion string, callback k8sclient.WatchPodsCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+				WithServiceLookup()(&w.Init.Options)
+				var response http.Response
+				var hosts []string
+				w.Init.TransportFunc = func(request *http.Request) (*http.Response, error) {
+					hosts = append(hosts, request.URL.Host)
+					return &response, nil
+				}
+				w.ExpOut.Response = unsafe.Pointer(&response)
+				t.Cleanup(func() {
+					// Requests for subsets do not change the affinity.
+					assert.Equal(t, hosts[0], hosts[len(hosts)-1])
+				})
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				request, err := http.NewRequest("GET", "kube-http://my-app.test/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+				_, err = w.KT.RoundTrip(request)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+				for i, ipAddress := range []string{"1.2.3.4", "2.3.4.5", "3.4.5.6"} {
+					if ipAddress == request.URL.Host {
+						continue
+					}
+					ctx := WithSubset(context.Background(), map[string]string{"pod": fmt.Sprintf("my-app-%d", i+1)})
+					request, err := http.NewRequestWithContext(ctx, "GET", "kube-http://my-app.test/aa/bb", nil)
+					if !assert.NoError(t, err) {
+						t.FailNow()
+					}
+					_, err = w.KT.RoundTrip(request)
+					if !assert.NoError(t, err) {
+						t.FailNow()
+					}
+					assert.Equal(t, ipAddress, request.URL.Host)
+				}
+				w.In.Request, err = http.NewRequest("GET", "kube-http://my-app.test/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				for i, endpointsName := range []string{"checkout-v1", "checkout-v2"} {
+					endpointsName := endpointsName
+					ipAddress := fmt.Sprintf("%d.%d.%d.%d", i+1, i+1, i+1, i+1)
+					w.MockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("shop"), gomock.Eq(endpointsName)).
+						DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+							return &k8sclient.Endpoints{
+								Metadata: k8sclient.Metadata{
+									ResourceVersion: "8910",
+								},
+								Subsets: []k8sclient.EndpointSubset{
+									{
+										Addresses: []k8sclient.EndpointAddress{
+											{IP: ipAddress},
+										},
+									},
+								},
+							}, nil
+						})
+					w.MockK8sClient.EXPECT().WatchEndpoints(gomock.Any(), gomock.Eq("shop"), gomock.Eq(endpointsName), gomock.Eq("8910"), gomock.Any()).
+						DoAndReturn(func(ctx context.Context, namespace, endpointsName, resourceVersion string, callback k8sclient.WatchEndpointsCallback) error {
+							<-ctx.Done()
+							return ctx.Err()
+						}).MinTimes(0)
+				}
+				w.Init.EndpointsRegistry.SetTrafficSplit("shop", "checkout", TrafficSplit{
+					Backends: []TrafficSplitBackend{
+						{EndpointsName: "checkout-v1", Weight: 90},
+						{EndpointsName: "checkout-v2", Weight: 0},
+					},
+				})
+				var response http.Response
+				var urls []string
+				w.Init.TransportFunc = func(request *http.Request) (*http.Response, error) {
+					urls = append(urls, request.URL.String())
+					return &response, nil
+				}
+				w.ExpOut.Response = unsafe.Pointer(&response)
+				t.Cleanup(func() {
+					assert.Equal(t, []string{
+						"http://1.1.1.1/aa/bb",
+						"http://1.1.1.1/aa/bb",
+						"http://2.2.2.2/aa/bb",
+					}, urls)
+				})
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				for i := 0; i < 2; i++ {
+					request, err := http.NewRequest("GET", "kube-http://checkout.shop/aa/bb", nil)
+					if !assert.NoError(t, err) {
+						t.FailNow()
+					}
+					_, err = w.KT.RoundTrip(request)
+					if !assert.NoError(t, err) {
+						t.FailNow()
+					}
+				}
+				w.Init.EndpointsRegistry.SetTrafficSplit("shop", "checkout", TrafficSplit{
+					Backends: []TrafficSplitBackend{
+						{EndpointsName: "checkout-v2", Weight: 10},
+					},
+				})
+				var err error
+				w.In.Request, err = http.NewRequest("GET", "kube-http://checkout.shop/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				for _, namespace := range []string{"a", "b"} {
+					namespace := namespace
+					var addresses []k8sclient.EndpointAddress
+					if namespace == "b" {
+						addresses = []k8sclient.EndpointAddress{{IP: "1.2.3.4"}}
+					}
+					w.MockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq(namespace), gomock.Eq("my-app")).
+						DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+							return &k8sclient.Endpoints{
+								Metadata: k8sclient.Metadata{
+									ResourceVersion: "8910",
+								},
+								Subsets: []k8sclient.EndpointSubset{
+									{
+										Addresses: addresses,
+									},
+								},
+							}, nil
+						})
+					w.MockK8sClient.EXPECT().WatchEndpoints(gomock.Any(), gomock.Eq(namespace), gomock.Eq("my-app"), gomock.Eq("8910"), gomock.Any()).
+						DoAndReturn(func(ctx context.Context, namespace, endpointsName, resourceVersion string, callback k8sclient.WatchEndpointsCallback) error {
+							<-ctx.Done()
+							return ctx.Err()
+						}).MinTimes(0)
+				}
+				w.Init.EndpointsRegistry.SetFailoverGroup("a", "my-app", FailoverGroup{
+					Services: []FailoverService{
+						{EndpointsName: "my-app"},
+						{Namespace: "b", EndpointsName: "my-app"},
+					},
+				})
+				tracer := new(recordingTracer)
+				w.Init.Options.Tracer = tracer
+				var response http.Response
+				w.Init.TransportFunc = func(request *http.Request) (*http.Response, error) {
+					assert.Equal(t, "http://1.2.3.4/aa/bb", request.URL.String())
+					return &response, nil
+				}
+				w.ExpOut.Response = unsafe.Pointer(&response)
+				t.Cleanup(func() {
+					assert.Equal(t, []string{
+						"start kubetransport.resolve [kubetransport.namespace=a kubetransport.endpoints_name=my-app]",
+						"end kubetransport.resolve [kubetransport.address_count=1 kubetransport.cache_hit=false kubetransport.failover_namespace=b kubetransport.failover_endpoints_name=my-app]",
+						"start kubetransport.pick [kubetransport.namespace=b kubetransport.endpoints_name=my-app kubetransport.address_count=1]",
+						"end kubetransport.pick [kubetransport.ip_address=1.2.3.4]",
+						"start kubetransport.resolve [kubetransport.namespace=a kubetransport.endpoints_name=my-app]",
+						"end kubetransport.resolve [kubetransport.address_count=1 kubetransport.cache_hit=true kubetransport.failover_namespace=b kubetransport.failover_endpoints_name=my-app]",
+						"start kubetransport.pick [kubetransport.namespace=b kubetransport.endpoints_name=my-app kubetransport.address_count=1]",
+						"end kubetransport.pick [kubetransport.ip_address=1.2.3.4]",
+					}, tracer.Records())
+					// Looking at the primary service, cached on the second request, counts as a
+					// cache hit, so that it is not evicted while failed over.
+					hitCounts := make(map[string]int64)
+					for _, snapshot := range w.Init.EndpointsRegistry.Snapshot() {
+						hitCounts[snapshot.Namespace] = snapshot.HitCount
+					}
+					assert.Equal(t, map[string]int64{"a": 2, "b": 3}, hitCounts)
+				})
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				request, err := http.NewRequest("GET", "kube-http://my-app.a/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+				_, err = w.KT.RoundTrip(request)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+				var resolutionInfos []ResolutionInfo
+				trace := ResolutionTrace{
+					GotEndpoint: func(resolutionInfo ResolutionInfo) {
+						resolutionInfo.WaitTime = 0
+						resolutionInfos = append(resolutionInfos, resolutionInfo)
+					},
+				}
+				ctx := WithResolutionTrace(context.Background(), &trace)
+				w.In.Request, err = http.NewRequestWithContext(ctx, "GET", "kube-http://my-app.a/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+				t.Cleanup(func() {
+					assert.Equal(t, []ResolutionInfo{
+						{Namespace: "b", EndpointsName: "my-app", IPAddress: "1.2.3.4", CacheHit: true, FailedOver: true, LogicalNamespace: "a", LogicalEndpointsName: "my-app"},
+					}, resolutionInfos)
+				})
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.MockK8sClient.EXPECT().ListEndpointSlices(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app")).
+					DoAndReturn(func(ctx context.Context, namespace, serviceName string) (*k8sclient.EndpointSliceList, error) {
+						hints := func(zone string) *k8sclient.EndpointHints {
+							return &k8sclient.EndpointHints{ForZones: []k8sclient.ForZone{{Name: zone}}}
+						}
+						return &k8sclient.EndpointSliceList{
+							Metadata: k8sclient.Metadata{
+								ResourceVersion: "8910",
+							},
+							Items: []k8sclient.EndpointSlice{
+								{
+									Metadata: k8sclient.Metadata{Name: "my-app-abcde"},
+									Endpoints: []k8sclient.Endpoint{
+										{Addresses: []string{"1.2.3.4"}, NodeName: "node-1", Zone: "zone-a", Hints: hints("zone-b")},
+										{Addresses: []string{"2.3.4.5"}, NodeName: "node-2", Zone: "zone-b", Hints: hints("zone-a")},
+										{Addresses: []string{"3.4.5.6"}, NodeName: "node-3", Zone: "zone-b", Hints: hints("zone-b")},
+									},
+								},
+							},
+						}, nil
+					})
+				w.MockK8sClient.EXPECT().WatchEndpointSlices(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app"), gomock.Eq("8910"), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, serviceName, resourceVersion string, callback k8sclient.WatchEndpointSlicesCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+				WithEndpointSlices()(&w.Init.Options)
+				WithZoneAwareRouting(ZoneAwareRouting{Zone: "zone-a"})(&w.Init.Options)
+				var response http.Response
+				w.Init.TransportFunc = func(request *http.Request) (*http.Response, error) {
+					assert.Equal(t, "http://2.3.4.5/aa/bb", request.URL.String())
+					return &response, nil
+				}
+				w.ExpOut.Response = unsafe.Pointer(&response)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				_, err := w.Init.EndpointsRegistry.GetIPAddresses(context.Background(), "test", "my-app")
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+				assert.Equal(t, "zone-a", w.Init.EndpointsRegistry.GetZone("test", "my-app", "1.2.3.4"))
+				assert.Equal(t, "zone-b", w.Init.EndpointsRegistry.GetZone("test", "my-app", "2.3.4.5"))
+				for i := 0; i < 10; i++ {
+					request, err := http.NewRequest("GET", "kube-http://my-app.test/aa/bb", nil)
+					if !assert.NoError(t, err) {
+						t.FailNow()
+					}
+					_, err = w.KT.RoundTrip(request)
+					if !assert.NoError(t, err) {
+						t.FailNow()
+					}
+				}
+				w.In.Request, err = http.NewRequest("GET", "kube-http://my-app.test/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.MockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("db")).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+						return &k8sclient.Endpoints{
+							Metadata: k8sclient.Metadata{
+								ResourceVersion: "8910",
+							},
+							Subsets: []k8sclient.EndpointSubset{
+								{
+									Addresses: []k8sclient.EndpointAddress{
+										{IP: "1.2.3.4", Hostname: "db-0"},
+										{IP: "2.3.4.5", Hostname: "db-1"},
+										{IP: "3.4.5.6", Hostname: "db-2"},
+									},
+								},
+							},
+						}, nil
+					})
+				w.MockK8sClient.EXPECT().WatchEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("db"), gomock.Eq("8910"), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName, resourceVersion string, callback k8sclient.WatchEndpointsCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+				w.Init.Options.MaxRetries = 0
+				w.Init.Options.CircuitBreaker = CircuitBreaker{ConsecutiveFailures: 1, OpenDuration: time.Hour}
+				w.Init.Options.PanicThreshold = 50
+				w.Init.Options.PanicModeHook = func(namespace, endpointsName string, panicking bool) {
+					t.Errorf("unexpected panic mode change: %v", panicking)
+				}
+				var response http.Response
+				var n int
+				w.Init.TransportFunc = func(request *http.Request) (*http.Response, error) {
+					n++
+					if n == 1 {
+						return &http.Response{StatusCode: http.StatusInternalServerError}, nil
+					}
+					assert.Equal(t, "http://2.3.4.5:5432/aa/bb", request.URL.String())
+					return &response, nil
+				}
+				w.ExpOut.Response = unsafe.Pointer(&response)
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				for i := 0; i < 2; i++ {
+					request, err := http.NewRequest("GET", "kube-http://db-0.db.test:5432/aa/bb", nil)
+					if !assert.NoError(t, err) {
+						t.FailNow()
+					}
+					_, err = w.KT.RoundTrip(request)
+					if i == 0 {
+						assert.NoError(t, err)
+					} else {
+						// The only endpoint is unhealthy but the service is not panicking.
+						assert.ErrorIs(t, err, ErrOverloaded)
+					}
+				}
+				var err error
+				w.In.Request, err = http.NewRequest("GET", "kube-http://db-1.db.test:5432/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.MockK8sClient.EXPECT().ListEndpointSlices(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app")).
+					DoAndReturn(func(ctx context.Context, namespace, serviceName string) (*k8sclient.EndpointSliceList, error) {
+						return &k8sclient.EndpointSliceList{
+							Metadata: k8sclient.Metadata{
+								ResourceVersion: "8910",
+							},
+							Items: []k8sclient.EndpointSlice{
+								{
+									Metadata:    k8sclient.Metadata{Name: "my-app-abcde"},
+									AddressType: k8sclient.AddressTypeIPv4,
+									Endpoints:   []k8sclient.Endpoint{{Addresses: []string{"1.2.3.4"}}},
+								},
+								{
+									Metadata:    k8sclient.Metadata{Name: "my-app-bcdef"},
+									AddressType: k8sclient.AddressTypeIPv6,
+									Endpoints:   []k8sclient.Endpoint{{Addresses: []string{"fd00::1"}}},
+								},
+								{
+									Metadata:    k8sclient.Metadata{Name: "my-app-cdefg"},
+									AddressType: k8sclient.AddressTypeFQDN,
+									Endpoints:   []k8sclient.Endpoint{{Addresses: []string{"my-app.example.com"}}},
+								},
+							},
+						}, nil
+					})
+				w.MockK8sClient.EXPECT().WatchEndpointSlices(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app"), gomock.Eq("8910"), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, serviceName, resourceVersion string, callback k8sclient.WatchEndpointSlicesCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+				w.MockK8sClient.EXPECT().ListEndpointSlices(gomock.Any(), gomock.Eq("test"), gomock.Eq("other-app")).
+					DoAndReturn(func(ctx context.Context, namespace, serviceName string) (*k8sclient.EndpointSliceList, error) {
+						return &k8sclient.EndpointSliceList{
+							Metadata: k8sclient.Metadata{
+								ResourceVersion: "8920",
+							},
+							Items: []k8sclient.EndpointSlice{
+								{
+									Metadata:    k8sclient.Metadata{Name: "other-app-abcde"},
+									AddressType: k8sclient.AddressTypeFQDN,
+									Endpoints:   []k8sclient.Endpoint{{Addresses: []string{"other-app.example.com"}}},
+								},
+							},
+						}, nil
+					})
+				w.MockK8sClient.EXPECT().WatchEndpointSlices(gomock.Any(), gomock.Eq("test"), gomock.Eq("other-app"), gomock.Eq("8920"), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, serviceName, resourceVersion string, callback k8sclient.WatchEndpointSlicesCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+				WithEndpointSlices()(&w.Init.Options)
+				var urls []string
+				w.Init.TransportFunc = func(request *http.Request) (*http.Response, error) {
+					urls = append(urls, request.URL.String())
+					return &http.Response{}, nil
+				}
+				w.ExpOut.Response = nil
+				w.ExpOut.Err = ErrNoIPAddress
+				w.ExpOut.ErrStr = "kubetransport: no ip address; namespace=\"test\" endpointsName=\"other-app\""
+				t.Cleanup(func() {
+					assert.Equal(t, []string{
+						"http://1.2.3.4/aa/bb",
+						"http://1.2.3.4/aa/bb",
+						"http://1.2.3.4/aa/bb",
+					}, urls)
+				})
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				for i := 0; i < 3; i++ {
+					request, err := http.NewRequest("GET", "kube-http://my-app.test/aa/bb", nil)
+					if !assert.NoError(t, err) {
+						t.FailNow()
+					}
+					_, err = w.KT.RoundTrip(request)
+					if !assert.NoError(t, err) {
+						t.FailNow()
+					}
+				}
+				var err error
+				w.In.Request, err = http.NewRequest("GET", "kube-http://other-app.test/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				gomock.InOrder(
+					w.MockK8sClient.EXPECT().ListEndpointSlices(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app")).
+						DoAndReturn(func(ctx context.Context, namespace, serviceName string) (*k8sclient.EndpointSliceList, error) {
+							return &k8sclient.EndpointSliceList{
+								Metadata: k8sclient.Metadata{
+									ResourceVersion: "8910",
+								},
+								Items: []k8sclient.EndpointSlice{
+									{
+										Metadata:  k8sclient.Metadata{Name: "my-app-abcde"},
+										Endpoints: []k8sclient.Endpoint{{Addresses: []string{"1.2.3.4"}}},
+									},
+									{
+										Metadata:  k8sclient.Metadata{Name: "my-app-bcdef"},
+										Endpoints: []k8sclient.Endpoint{{Addresses: []string{"2.3.4.5"}}},
+									},
+								},
+							}, nil
+						}),
+					w.MockK8sClient.EXPECT().WatchEndpointSlices(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app"), gomock.Eq("8910"), gomock.Any()).
+						DoAndReturn(func(ctx context.Context, namespace, serviceName, resourceVersion string, callback k8sclient.WatchEndpointSlicesCallback) error {
+							time.Sleep(MinWatchDuration)
+							// The endpoint slice my-app-bcdef is deleted meanwhile.
+							return fmt.Errorf("receive error event: %w", k8sclient.ErrResourceVersionExpired)
+						}),
+					w.MockK8sClient.EXPECT().ListEndpointSlices(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app")).
+						DoAndReturn(func(ctx context.Context, namespace, serviceName string) (*k8sclient.EndpointSliceList, error) {
+							return &k8sclient.EndpointSliceList{
+								Metadata: k8sclient.Metadata{
+									ResourceVersion: "8950",
+								},
+								Items: []k8sclient.EndpointSlice{
+									{
+										Metadata:  k8sclient.Metadata{Name: "my-app-abcde"},
+										Endpoints: []k8sclient.Endpoint{{Addresses: []string{"1.2.3.4"}}},
+									},
+								},
+							}, nil
+						}),
+				)
+				w.MockK8sClient.EXPECT().WatchEndpointSlices(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app"), gomock.Eq("8950"), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, serviceName, resourceVersion string, callback k8sclient.WatchEndpointSlicesCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					})
+				WithEndpointSlices()(&w.Init.Options)
+				var response http.Response
+				var urls []string
+				w.Init.TransportFunc = func(request *http.Request) (*http.Response, error) {
+					urls = append(urls, request.URL.String())
+					return &response, nil
+				}
+				w.ExpOut.Response = unsafe.Pointer(&response)
+				t.Cleanup(func() {
+					assert.Equal(t, []string{
+						"http://1.2.3.4/aa/bb",
+						"http://1.2.3.4/aa/bb",
+						"http://1.2.3.4/aa/bb",
+					}, urls)
+				})
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				for {
+					ipAddresses, _, err := w.Init.EndpointsRegistry.LookUpIPAddresses(context.Background(), "test", "my-app")
+					if !assert.NoError(t, err) {
+						t.FailNow()
+					}
+					if len(ipAddresses) == 1 {
+						break
+					}
+					time.Sleep(MinWatchDuration / 10)
+				}
+				for i := 0; i < 2; i++ {
+					request, err := http.NewRequest("GET", "kube-http://my-app.test/aa/bb", nil)
+					if !assert.NoError(t, err) {
+						t.FailNow()
+					}
+					_, err = w.KT.RoundTrip(request)
+					if !assert.NoError(t, err) {
+						t.FailNow()
+					}
+				}
+				var err error
+				w.In.Request, err = http.NewRequest("GET", "kube-http://my-app.test/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.MockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app")).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+						return &k8sclient.Endpoints{
+							Metadata: k8sclient.Metadata{
+								ResourceVersion: "8910",
+							},
+							Subsets: []k8sclient.EndpointSubset{
+								{
+									Addresses: []k8sclient.EndpointAddress{
+										{IP: "1.2.3.4"},
+									},
+								},
+							},
+						}, nil
+					})
+				w.MockK8sClient.EXPECT().WatchEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app"), gomock.Eq("8910"), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, namespace, endpointsName, resourceVersion string, callback k8sclient.WatchEndpointsCallback) error {
+						<-ctx.Done()
+						return ctx.Err()
+					}).MinTimes(0)
+				w.Init.Options.ServiceLimits = Limits{MaxConcurrentRequests: 1}
+				var connection upgradedConnection
+				response := http.Response{StatusCode: http.StatusSwitchingProtocols, Body: &connection}
+				w.Init.TransportFunc = func(request *http.Request) (*http.Response, error) {
+					return &response, nil
+				}
+				w.ExpOut.Response = unsafe.Pointer(&response)
+				t.Cleanup(func() {
+					writer, ok := response.Body.(io.ReadWriteCloser)
+					if !assert.True(t, ok) {
+						return
+					}
+					_, err := writer.Write([]byte("hello"))
+					assert.NoError(t, err)
+					assert.Equal(t, "hello", connection.String())
+					assert.NoError(t, writer.Close())
+					assert.True(t, connection.Closed)
+					// The slot of the limiter is released once the connection is closed.
+					request, err := http.NewRequest("GET", "kube-http://my-app.test/aa/bb", nil)
+					if !assert.NoError(t, err) {
+						return
+					}
+					_, err = w.KT.RoundTrip(request)
+					assert.NoError(t, err)
+				})
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				var err error
+				w.In.Request, err = http.NewRequest("GET", "kube-http://my-app.test/aa/bb", nil)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+				w.In.Request.Header.Set("Connection", "Upgrade")
+				w.In.Request.Header.Set("Upgrade", "websocket")
+			}),
 	)
 }
 
+type upgradedConnection struct {
+	bytes.Buffer
+
+	Closed bool
+}
+
+var _ io.ReadWriteCloser = (*upgradedConnection)(nil)
+
+func (uc *upgradedConnection) Close() error {
+	uc.Closed = true
+	return nil
+}
+
+type recordingTracer struct {
+	lock    sync.Mutex
+	records []string
+}
+
+var _ Tracer = (*recordingTracer)(nil)
+
+func (rt *recordingTracer) StartSpan(ctx context.Context, name string, attributes []Attribute) (context.Context, Span) {
+	rt.record("start", name, attributes)
+	return ctx, recordingSpan{rt, name}
+}
+
+func (rt *recordingTracer) record(event string, name string, attributes []Attribute) {
+	record := event + " " + name + " ["
+	for i, attribute := range attributes {
+		if i >= 1 {
+			record += " "
+		}
+		record += fmt.Sprintf("%s=%v", attribute.Key, attribute.Value)
+	}
+	record += "]"
+	rt.lock.Lock()
+	rt.records = append(rt.records, record)
+	rt.lock.Unlock()
+}
+
+func (rt *recordingTracer) Records() []string {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	return append([]string(nil), rt.records...)
+}
+
+type recordingSpan struct {
+	tracer *recordingTracer
+	name   string
+}
+
+func (rs recordingSpan) End(attributes []Attribute, _ error) {
+	rs.tracer.record("end", rs.name, attributes)
+}
+
 type transportFunc func(*http.Request) (*http.Response, error)
 
 var _ http.RoundTripper = transportFunc(nil)
@@ -283,3 +1981,154 @@ var _ http.RoundTripper = transportFunc(nil)
 func (tf transportFunc) RoundTrip(request *http.Request) (*http.Response, error) {
 	return tf(request)
 }
+
+func TestKubeTransport_CloseIdleConnections(t *testing.T) {
+	type Workspace struct {
+		Init struct {
+			Options           Options
+			EndpointsRegistry *EndpointsRegistry
+			Transport         *closeIdlerTransport
+		}
+		ExpOut, ActOut struct {
+			CloseIdleConnectionsCounts []int
+		}
+
+		MockK8sClient *mock_k8sclient.MockK8sClient
+		Callback      chan k8sclient.WatchEndpointsCallback
+		KT            *KubeTransport
+	}
+	tc := testcase.New().
+		Step(0, func(t *testing.T, w *Workspace) {
+			ctrl := gomock.NewController(t)
+			w.MockK8sClient = mock_k8sclient.NewMockK8sClient(ctrl)
+			w.MockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app")).
+				DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+					return &k8sclient.Endpoints{
+						Metadata: k8sclient.Metadata{
+							ResourceVersion: "8910",
+						},
+						Subsets: []k8sclient.EndpointSubset{
+							{
+								Addresses: []k8sclient.EndpointAddress{
+									{IP: "1.2.3.4"},
+								},
+							},
+						},
+					}, nil
+				})
+			w.Callback = make(chan k8sclient.WatchEndpointsCallback, 1)
+			w.MockK8sClient.EXPECT().WatchEndpoints(gomock.Any(), gomock.Eq("test"), gomock.Eq("my-app"), gomock.Eq("8910"), gomock.Any()).
+				DoAndReturn(func(ctx context.Context, namespace, endpointsName, resourceVersion string, callback k8sclient.WatchEndpointsCallback) error {
+					w.Callback <- callback
+					<-ctx.Done()
+					return ctx.Err()
+				})
+			w.Init.Options = MakeOptions(nil)
+			w.Init.EndpointsRegistry = NewEndpointsRegistry(context.Background(), w.MockK8sClient, &w.Init.Options, 24*time.Hour)
+			t.Cleanup(w.Init.EndpointsRegistry.Stop)
+			w.Init.Transport = &closeIdlerTransport{}
+		}).
+		Step(1, func(t *testing.T, w *Workspace) {
+			stateRegistry := NewStateRegistry(&w.Init.Options, clock.New())
+			w.KT = NewKubeTransport(w.Init.EndpointsRegistry, stateRegistry, &w.Init.Options, w.Init.Transport, 0)
+		}).
+		Step(2, func(t *testing.T, w *Workspace) {
+			request, err := http.NewRequest("GET", "kube-http://my-app.test/aa/bb", nil)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			response, err := w.KT.RoundTrip(request)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			callback := <-w.Callback
+			w.ActOut.CloseIdleConnectionsCounts = append(w.ActOut.CloseIdleConnectionsCounts, w.Init.Transport.CloseIdleConnectionsCount())
+			callback(k8sclient.EventModified, &k8sclient.Endpoints{
+				Subsets: []k8sclient.EndpointSubset{
+					{
+						Addresses: []k8sclient.EndpointAddress{
+							{IP: "2.3.4.5"},
+						},
+					},
+				},
+			})
+			w.ActOut.CloseIdleConnectionsCounts = append(w.ActOut.CloseIdleConnectionsCounts, w.Init.Transport.CloseIdleConnectionsCount())
+			response.Body.Close()
+			w.ActOut.CloseIdleConnectionsCounts = append(w.ActOut.CloseIdleConnectionsCounts, w.Init.Transport.CloseIdleConnectionsCount())
+			callback(k8sclient.EventModified, &k8sclient.Endpoints{})
+			w.ActOut.CloseIdleConnectionsCounts = append(w.ActOut.CloseIdleConnectionsCounts, w.Init.Transport.CloseIdleConnectionsCount())
+		}).
+		Step(3, func(t *testing.T, w *Workspace) {
+			assert.Equal(t, w.ExpOut, w.ActOut)
+		})
+	testcase.RunListParallel(t,
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.Options.CloseIdleConnections = true
+				w.ExpOut.CloseIdleConnectionsCounts = []int{0, 0, 1, 2}
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.ExpOut.CloseIdleConnectionsCounts = []int{0, 0, 0, 0}
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.Options.CloseIdleConnections = true
+				w.ExpOut.CloseIdleConnectionsCounts = []int{0, 0, 1, 2}
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				stateRegistry := NewStateRegistry(&w.Init.Options, clock.New())
+				NewKubeTransport(w.Init.EndpointsRegistry, stateRegistry, &w.Init.Options, w.Init.Transport, 0)
+				assert.Equal(t, 2, w.Init.EndpointsRegistry.RemovalCallbackCount())
+				for i := 0; i < 100 && w.Init.EndpointsRegistry.RemovalCallbackCount() == 2; i++ {
+					runtime.GC()
+					time.Sleep(10 * time.Millisecond)
+				}
+				assert.Equal(t, 1, w.Init.EndpointsRegistry.RemovalCallbackCount())
+			}),
+	)
+}
+
+func TestIdleConnectionCloser(t *testing.T) {
+	transport := &closeIdlerTransport{}
+	icc := NewIdleConnectionCloser(transport)
+	var closeIdleConnectionsCounts []int
+	record := func() {
+		closeIdleConnectionsCounts = append(closeIdleConnectionsCounts, transport.CloseIdleConnectionsCount())
+	}
+	icc.BeginRequest("1.2.3.4")
+	icc.BeginRequest("2.3.4.5")
+	icc.BeginRequest("2.3.4.5")
+	icc.RemoveIPAddresses([]string{"1.2.3.4"})
+	record()
+	icc.RemoveIPAddresses([]string{"2.3.4.5", "3.4.5.6"})
+	record()
+	icc.EndRequest("2.3.4.5")
+	record()
+	// The batch drained is not held up by the busy ip address removed before.
+	icc.EndRequest("2.3.4.5")
+	record()
+	icc.RemoveIPAddresses([]string{"4.5.6.7"})
+	record()
+	icc.EndRequest("1.2.3.4")
+	record()
+	assert.Equal(t, []int{0, 0, 0, 1, 2, 3}, closeIdleConnectionsCounts)
+}
+
+type closeIdlerTransport struct {
+	closeIdleConnectionsCount int32
+}
+
+var _ http.RoundTripper = (*closeIdlerTransport)(nil)
+
+func (cit *closeIdlerTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return &http.Response{Body: http.NoBody}, nil
+}
+
+func (cit *closeIdlerTransport) CloseIdleConnections() {
+	atomic.AddInt32(&cit.closeIdleConnectionsCount, 1)
+}
+
+func (cit *closeIdlerTransport) CloseIdleConnectionsCount() int {
+	return int(atomic.LoadInt32(&cit.closeIdleConnectionsCount))
+}
@@ -0,0 +1,19 @@
+package kubetransport
+
+// Logger is a structured logger in the style of log/slog, which *slog.Logger satisfies.
+// The args are alternating keys and values.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+type dummyLogger struct{}
+
+var _ Logger = dummyLogger{}
+
+func (dummyLogger) Debug(string, ...interface{}) {}
+func (dummyLogger) Info(string, ...interface{})  {}
+func (dummyLogger) Warn(string, ...interface{})  {}
+func (dummyLogger) Error(string, ...interface{}) {}
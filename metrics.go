@@ -0,0 +1,334 @@
+package kubetransport
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MetricsCollector collects the metrics of the transport wrapper.
+// Implementations must be safe for concurrent use.
+type MetricsCollector interface {
+	// AddCounter adds the given value to a counter.
+	AddCounter(name string, labels []Label, value float64)
+
+	// SetGauge sets a gauge to the given value.
+	SetGauge(name string, labels []Label, value float64)
+
+	// ObserveHistogram adds an observation to a histogram.
+	ObserveHistogram(name string, labels []Label, value float64)
+}
+
+// MetricsDeleter can be implemented by a MetricsCollector to delete the series of the
+// endpoints (pods) removed, which are labeled with their ip addresses, so that they do not
+// pile up with pod churn.
+type MetricsDeleter interface {
+	// DeleteSeries deletes the series of all the metrics with all the given labels.
+	DeleteSeries(labels []Label)
+}
+
+// Label is a name-value pair attached to a metric.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// The names of the metrics collected.
+const (
+	// MetricCacheHits counts the resolutions served from the cache.
+	// Labels: namespace, endpoints_name.
+	MetricCacheHits = "kubetransport_cache_hits_total"
+
+	// MetricCacheMisses counts the resolutions not served from the cache.
+	// Labels: namespace, endpoints_name.
+	MetricCacheMisses = "kubetransport_cache_misses_total"
+
+	// MetricCacheEvictions counts the endpoints evicted from the cache for being unused.
+	// Labels: namespace, endpoints_name.
+	MetricCacheEvictions = "kubetransport_cache_evictions_total"
+
+	// MetricResolutionDuration observes the time taken to resolve hostnames in seconds.
+	// Labels: namespace, endpoints_name (of the service requested, even if failed over).
+	MetricResolutionDuration = "kubetransport_resolution_duration_seconds"
+
+	// MetricWatchRestarts counts the watches restarted after failures.
+	// Labels: namespace, endpoints_name.
+	MetricWatchRestarts = "kubetransport_watch_restarts_total"
+
+	// MetricWatchErrors counts the watch failures.
+	// Labels: namespace, endpoints_name.
+	MetricWatchErrors = "kubetransport_watch_errors_total"
+
+	// MetricEndpointRequests counts the requests sent to endpoints (pods). The code label is
+	// the status code of the response, or "error" if no response is received.
+	// Labels: namespace, endpoints_name, ip_address, code.
+	MetricEndpointRequests = "kubetransport_endpoint_requests_total"
+
+	// MetricEndpointRequestDuration observes the time taken to receive responses from
+	// endpoints (pods) in seconds.
+	// Labels: namespace, endpoints_name, ip_address.
+	MetricEndpointRequestDuration = "kubetransport_endpoint_request_duration_seconds"
+
+	// MetricAddresses is the current number of ip addresses of endpoints.
+	// Labels: namespace, endpoints_name.
+	MetricAddresses = "kubetransport_addresses"
+
+	// MetricPropagationDelay observes the delay in seconds between the changes of endpoints,
+	// as told by the endpoints.kubernetes.io/last-change-trigger-time annotation, and the
+	// arrival of the watch events.
+	// Labels: namespace, endpoints_name.
+	MetricPropagationDelay = "kubetransport_propagation_delay_seconds"
+
+	// MetricPanicMode is 1 if a service is in the panic mode, otherwise 0.
+	// Labels: namespace, endpoints_name.
+	MetricPanicMode = "kubetransport_panic_mode"
+)
+
+// deleteEndpointMetrics deletes the series of the endpoints (pods) with the given ip
+// addresses of the given endpoints, if the metrics collector supports it. The series of the
+// same pods behind other services are kept.
+func deleteEndpointMetrics(metricsCollector MetricsCollector, namespace string, endpointsName string, ipAddresses []string) {
+	metricsDeleter, ok := metricsCollector.(MetricsDeleter)
+	if !ok {
+		return
+	}
+	for _, ipAddress := range ipAddresses {
+		metricsDeleter.DeleteSeries(append(endpointsLabels(namespace, endpointsName), Label{"ip_address", ipAddress}))
+	}
+}
+
+func endpointsLabels(namespace string, endpointsName string) []Label {
+	return []Label{{"namespace", namespace}, {"endpoints_name", endpointsName}}
+}
+
+type dummyMetricsCollector struct{}
+
+var _ MetricsCollector = dummyMetricsCollector{}
+
+func (dummyMetricsCollector) AddCounter(string, []Label, float64)       {}
+func (dummyMetricsCollector) SetGauge(string, []Label, float64)         {}
+func (dummyMetricsCollector) ObserveHistogram(string, []Label, float64) {}
+
+// Metrics is a built-in MetricsCollector which keeps the metrics in memory and serves
+// them over HTTP in the Prometheus text format.
+type Metrics struct {
+	buckets []float64
+
+	lock     sync.Mutex
+	families map[string]*metricFamily
+}
+
+var (
+	_ MetricsCollector = (*Metrics)(nil)
+	_ MetricsDeleter   = (*Metrics)(nil)
+	_ http.Handler     = (*Metrics)(nil)
+)
+
+// DefaultHistogramBuckets are the default upper bounds of the histogram buckets in seconds.
+var DefaultHistogramBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// NewMetrics creates a Metrics with the given upper bounds of the histogram buckets.
+// If no bucket is given, DefaultHistogramBuckets is used.
+func NewMetrics(buckets ...float64) *Metrics {
+	var m Metrics
+	if len(buckets) == 0 {
+		buckets = DefaultHistogramBuckets
+	}
+	m.buckets = append([]float64(nil), buckets...)
+	sort.Float64s(m.buckets)
+	m.families = make(map[string]*metricFamily)
+	return &m
+}
+
+type metricFamily struct {
+	Type   string
+	Series map[string]*metricSeries
+}
+
+type metricSeries struct {
+	Labels       []Label
+	Value        float64
+	BucketCounts []uint64
+	Sum          float64
+	Count        uint64
+}
+
+// AddCounter implements MetricsCollector.AddCounter.
+func (m *Metrics) AddCounter(name string, labels []Label, value float64) {
+	m.lock.Lock()
+	if series := m.getSeries(name, "counter", labels); series != nil {
+		series.Value += value
+	}
+	m.lock.Unlock()
+}
+
+// SetGauge implements MetricsCollector.SetGauge.
+func (m *Metrics) SetGauge(name string, labels []Label, value float64) {
+	m.lock.Lock()
+	if series := m.getSeries(name, "gauge", labels); series != nil {
+		series.Value = value
+	}
+	m.lock.Unlock()
+}
+
+// ObserveHistogram implements MetricsCollector.ObserveHistogram.
+func (m *Metrics) ObserveHistogram(name string, labels []Label, value float64) {
+	m.lock.Lock()
+	if series := m.getSeries(name, "histogram", labels); series != nil {
+		if series.BucketCounts == nil {
+			series.BucketCounts = make([]uint64, len(m.buckets))
+		}
+		for i, bucket := range m.buckets {
+			if value <= bucket {
+				series.BucketCounts[i]++
+			}
+		}
+		series.Sum += value
+		series.Count++
+	}
+	m.lock.Unlock()
+}
+
+// DeleteSeries implements MetricsDeleter.DeleteSeries.
+func (m *Metrics) DeleteSeries(labels []Label) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for name, family := range m.families {
+		for key, series := range family.Series {
+			if hasLabels(series.Labels, labels) {
+				delete(family.Series, key)
+			}
+		}
+		if len(family.Series) == 0 {
+			delete(m.families, name)
+		}
+	}
+}
+
+func hasLabels(labels []Label, subLabels []Label) bool {
+	for _, subLabel := range subLabels {
+		if !hasLabel(labels, subLabel) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasLabel(labels []Label, label Label) bool {
+	for _, otherLabel := range labels {
+		if otherLabel == label {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Metrics) getSeries(name string, metricType string, labels []Label) *metricSeries {
+	family, ok := m.families[name]
+	if !ok {
+		family = &metricFamily{
+			Type:   metricType,
+			Series: make(map[string]*metricSeries),
+		}
+		m.families[name] = family
+	} else if family.Type != metricType {
+		return nil
+	}
+	key := formatLabels(labels)
+	series, ok := family.Series[key]
+	if !ok {
+		series = &metricSeries{Labels: append([]Label(nil), labels...)}
+		family.Series[key] = series
+	}
+	return series
+}
+
+func formatLabels(labels []Label) string {
+	var builder strings.Builder
+	for i, label := range labels {
+		if i >= 1 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(label.Name)
+		builder.WriteString(`="`)
+		builder.WriteString(labelValueEscaper.Replace(label.Value))
+		builder.WriteByte('"')
+	}
+	return builder.String()
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(responseWriter http.ResponseWriter, _ *http.Request) {
+	responseWriter.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writer := bufio.NewWriter(responseWriter)
+	m.writeText(writer)
+	writer.Flush()
+}
+
+func (m *Metrics) writeText(writer *bufio.Writer) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		family := m.families[name]
+		fmt.Fprintf(writer, "# TYPE %s %s\n", name, family.Type)
+		keys := make([]string, 0, len(family.Series))
+		for key := range family.Series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			series := family.Series[key]
+			if family.Type != "histogram" {
+				writeSample(writer, name, key, "", series.Value)
+				continue
+			}
+			for i, bucket := range m.buckets {
+				writeSample(writer, name+"_bucket", key, `le="`+formatValue(bucket)+`"`, float64(series.BucketCounts[i]))
+			}
+			writeSample(writer, name+"_bucket", key, `le="+Inf"`, float64(series.Count))
+			writeSample(writer, name+"_sum", key, "", series.Sum)
+			writeSample(writer, name+"_count", key, "", float64(series.Count))
+		}
+	}
+}
+
+func writeSample(writer *bufio.Writer, name string, labels string, extraLabel string, value float64) {
+	writer.WriteString(name)
+	if labels != "" || extraLabel != "" {
+		writer.WriteByte('{')
+		writer.WriteString(labels)
+		if labels != "" && extraLabel != "" {
+			writer.WriteByte(',')
+		}
+		writer.WriteString(extraLabel)
+		writer.WriteByte('}')
+	}
+	writer.WriteByte(' ')
+	writer.WriteString(formatValue(value))
+	writer.WriteByte('\n')
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, +1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
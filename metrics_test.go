@@ -0,0 +1,91 @@
+package kubetransport_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/go-tk/kubetransport"
+	"github.com/go-tk/testcase"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics_ServeHTTP(t *testing.T) {
+	type Workspace struct {
+		Init struct {
+			Buckets []float64
+		}
+		ExpOut, ActOut struct {
+			ContentType string
+			Body        string
+		}
+
+		M *Metrics
+	}
+	tc := testcase.New().
+		Step(1, func(t *testing.T, w *Workspace) {
+			w.M = NewMetrics(w.Init.Buckets...)
+		}).
+		Step(2, func(t *testing.T, w *Workspace) {
+			responseRecorder := httptest.NewRecorder()
+			w.M.ServeHTTP(responseRecorder, httptest.NewRequest("GET", "/metrics", nil))
+			w.ActOut.ContentType = responseRecorder.Header().Get("Content-Type")
+			w.ActOut.Body = responseRecorder.Body.String()
+		}).
+		Step(3, func(t *testing.T, w *Workspace) {
+			assert.Equal(t, w.ExpOut, w.ActOut)
+		})
+	testcase.RunListParallel(t,
+		tc.Copy().
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				w.ExpOut.ContentType = "text/plain; version=0.0.4; charset=utf-8"
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.Buckets = []float64{1, 0.5}
+			}).
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				labels := []Label{{"namespace", "foo"}, {"endpoints_name", "b\"a\\r"}}
+				w.M.AddCounter(MetricCacheHits, labels, 1)
+				w.M.AddCounter(MetricCacheHits, labels, 2)
+				w.M.AddCounter(MetricCacheHits, nil, 1)
+				w.M.SetGauge(MetricAddresses, labels, 3)
+				w.M.SetGauge(MetricAddresses, labels, 2)
+				w.M.SetGauge(MetricCacheHits, labels, 100)
+				w.M.ObserveHistogram(MetricResolutionDuration, labels, 0.25)
+				w.M.ObserveHistogram(MetricResolutionDuration, labels, 0.75)
+				w.M.ObserveHistogram(MetricResolutionDuration, labels, 2)
+				w.ExpOut.ContentType = "text/plain; version=0.0.4; charset=utf-8"
+				w.ExpOut.Body = `# TYPE kubetransport_addresses gauge
+kubetransport_addresses{namespace="foo",endpoints_name="b\"a\\r"} 2
+# TYPE kubetransport_cache_hits_total counter
+kubetransport_cache_hits_total 1
+kubetransport_cache_hits_total{namespace="foo",endpoints_name="b\"a\\r"} 3
+# TYPE kubetransport_resolution_duration_seconds histogram
+kubetransport_resolution_duration_seconds_bucket{namespace="foo",endpoints_name="b\"a\\r",le="0.5"} 1
+kubetransport_resolution_duration_seconds_bucket{namespace="foo",endpoints_name="b\"a\\r",le="1"} 2
+kubetransport_resolution_duration_seconds_bucket{namespace="foo",endpoints_name="b\"a\\r",le="+Inf"} 3
+kubetransport_resolution_duration_seconds_sum{namespace="foo",endpoints_name="b\"a\\r"} 3
+kubetransport_resolution_duration_seconds_count{namespace="foo",endpoints_name="b\"a\\r"} 3
+`
+			}),
+		tc.Copy().
+			Step(1.5, func(t *testing.T, w *Workspace) {
+				labels1 := []Label{{"namespace", "foo"}, {"endpoints_name", "bar"}, {"ip_address", "1.2.3.4"}}
+				labels2 := []Label{{"namespace", "foo"}, {"endpoints_name", "bar"}, {"ip_address", "2.3.4.5"}}
+				labels3 := []Label{{"namespace", "foo"}, {"endpoints_name", "baz"}, {"ip_address", "1.2.3.4"}}
+				w.M.AddCounter(MetricEndpointRequests, append(labels1, Label{"code", "200"}), 1)
+				w.M.AddCounter(MetricEndpointRequests, append(labels2, Label{"code", "200"}), 1)
+				w.M.AddCounter(MetricEndpointRequests, append(labels3, Label{"code", "200"}), 1)
+				w.M.ObserveHistogram(MetricEndpointRequestDuration, labels1, 0.25)
+				w.M.SetGauge(MetricAddresses, labels1[:2], 2)
+				w.M.DeleteSeries(labels1)
+				w.ExpOut.ContentType = "text/plain; version=0.0.4; charset=utf-8"
+				w.ExpOut.Body = `# TYPE kubetransport_addresses gauge
+kubetransport_addresses{namespace="foo",endpoints_name="bar"} 2
+# TYPE kubetransport_endpoint_requests_total counter
+kubetransport_endpoint_requests_total{namespace="foo",endpoints_name="bar",ip_address="2.3.4.5",code="200"} 1
+kubetransport_endpoint_requests_total{namespace="foo",endpoints_name="baz",ip_address="1.2.3.4",code="200"} 1
+`
+			}),
+	)
+}
@@ -0,0 +1,77 @@
+package kubetransport
+
+import "context"
+
+// NotReadyPolicy is the policy to use the endpoints (pods) which are not ready, i.e. those
+// in notReadyAddresses of the endpoints.
+type NotReadyPolicy int
+
+const (
+	// NotReadyPolicyNever never uses the endpoints which are not ready.
+	NotReadyPolicyNever NotReadyPolicy = iota
+
+	// NotReadyPolicyFallback uses the endpoints which are not ready only if no endpoint is
+	// ready, e.g. when a dependency blip makes all the pods unready.
+	NotReadyPolicyFallback
+
+	// NotReadyPolicyAlways uses the endpoints whether they are ready or not, e.g. for
+	// administrative tools.
+	NotReadyPolicyAlways
+)
+
+// WithNotReadyPolicy sets the not-ready policy for all the requests. Defaults to
+// NotReadyPolicyNever.
+func WithNotReadyPolicy(notReadyPolicy NotReadyPolicy) Option {
+	return func(options *options) { options.NotReadyPolicy = notReadyPolicy }
+}
+
+type notReadyPolicyKey struct{}
+
+// WithRequestNotReadyPolicy returns a copy of the given context which overrides the
+// not-ready policy for the requests sent with it.
+func WithRequestNotReadyPolicy(ctx context.Context, notReadyPolicy NotReadyPolicy) context.Context {
+	return context.WithValue(ctx, notReadyPolicyKey{}, notReadyPolicy)
+}
+
+func (kt *kubeTransport) notReadyPolicy(ctx context.Context) NotReadyPolicy {
+	if notReadyPolicy, ok := ctx.Value(notReadyPolicyKey{}).(NotReadyPolicy); ok {
+		return notReadyPolicy
+	}
+	return kt.options.NotReadyPolicy
+}
+
+// applyNotReadyPolicy adds the ip addresses of the endpoints which are not ready to the
+// given ip addresses of the ready ones according to the not-ready policy.
+func (kt *kubeTransport) applyNotReadyPolicy(ctx context.Context, namespace string, endpointsName string, ipAddresses []string) []string {
+	switch kt.notReadyPolicy(ctx) {
+	case NotReadyPolicyFallback:
+		if len(ipAddresses) >= 1 {
+			return ipAddresses
+		}
+	case NotReadyPolicyAlways:
+	default:
+		return ipAddresses
+	}
+	notReadyIPAddresses := kt.endpointsRegistry.GetNotReadyIPAddresses(namespace, endpointsName)
+	if len(notReadyIPAddresses) == 0 {
+		return ipAddresses
+	}
+	return append(append(make([]string, 0, len(ipAddresses)+len(notReadyIPAddresses)), ipAddresses...), notReadyIPAddresses...)
+}
+
+// GetNotReadyIPAddresses returns the ip addresses of the endpoints (pods) which are not
+// ready in the cached endpoints.
+func (er *endpointsRegistry) GetNotReadyIPAddresses(namespace string, endpointsName string) []string {
+	if namespace == "" {
+		namespace = er.k8sClient.Namespace()
+	}
+	value, ok := er.ipAddressesCache.Load(endpointKey{namespace, endpointsName})
+	if !ok {
+		return nil
+	}
+	cachedIPAddresses, ok := value.(*cachedIPAddresses)
+	if !ok {
+		return nil
+	}
+	return cachedIPAddresses.NotReadyValue
+}
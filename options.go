@@ -0,0 +1,157 @@
+package kubetransport
+
+import "time"
+
+// Option is an option of the transport wrapper.
+type Option func(*options)
+
+type options struct {
+	EndpointLimits   Limits
+	ServiceLimits    Limits
+	CircuitBreaker   CircuitBreaker
+	HedgingBudget    float64
+	MaxRetries       int
+	RetryBudget      float64
+	PanicThreshold   float64
+	PanicModeHook    PanicModeHook
+	MetricsCollector MetricsCollector
+	Logger           Logger
+	EndpointHeader   string
+	Tracer           Tracer
+	HealthThresholds HealthThresholds
+	ZoneAwareRouting *ZoneAwareRouting
+	LocalZone        string
+	NodeName         string
+	NodeLocalPolicy  NodeLocalPolicy
+	ServiceLookup    bool
+	NotReadyPolicy   NotReadyPolicy
+	EndpointSlices   bool
+	TrafficSplits    map[endpointKey]TrafficSplit
+	FailoverGroups   map[endpointKey]FailoverGroup
+
+	CloseIdleConnections bool
+}
+
+func makeOptions(optionList []Option) options {
+	options := options{
+		HedgingBudget:    0.1,
+		RetryBudget:      0.2,
+		PanicThreshold:   50,
+		MetricsCollector: dummyMetricsCollector{},
+		Logger:           dummyLogger{},
+		Tracer:           dummyTracer{},
+		HealthThresholds: HealthThresholds{
+			MaxSyncAge:             10 * time.Minute,
+			MaxUnreachableDuration: 10 * time.Minute,
+		},
+	}
+	for _, option := range optionList {
+		option(&options)
+	}
+	return options
+}
+
+// Limits limits the requests sent to an endpoint or a service.
+type Limits struct {
+	// MaxConcurrentRequests is the maximum number of requests in flight.
+	// Zero means no limit.
+	MaxConcurrentRequests int
+
+	// MaxPendingRequests is the maximum number of requests waiting for
+	// the number of requests in flight to drop below MaxConcurrentRequests.
+	// Requests beyond it fail with ErrOverloaded.
+	MaxPendingRequests int
+}
+
+// WithEndpointLimits sets the limits for each endpoint (pod).
+func WithEndpointLimits(limits Limits) Option {
+	return func(options *options) { options.EndpointLimits = limits }
+}
+
+// WithServiceLimits sets the limits for each service.
+func WithServiceLimits(limits Limits) Option {
+	return func(options *options) { options.ServiceLimits = limits }
+}
+
+// CircuitBreaker configures the circuit breaker of each endpoint (pod).
+type CircuitBreaker struct {
+	// ConsecutiveFailures is the number of consecutive failures (transport errors or
+	// 5xx responses) which opens the circuit breaker. Zero disables the circuit breaker.
+	ConsecutiveFailures int
+
+	// OpenDuration is how long the circuit breaker stays open before letting a probe
+	// request through (half-open).
+	OpenDuration time.Duration
+}
+
+// WithCircuitBreaker enables the circuit breaker for each endpoint (pod).
+func WithCircuitBreaker(circuitBreaker CircuitBreaker) Option {
+	return func(options *options) { options.CircuitBreaker = circuitBreaker }
+}
+
+// WithHedgingBudget limits the hedged requests to the given ratio of the requests sent to
+// each service. Defaults to 0.1.
+func WithHedgingBudget(ratio float64) Option {
+	return func(options *options) { options.HedgingBudget = ratio }
+}
+
+// WithRetries enables retrying idempotent requests on other endpoints (pods) up to the given
+// number of times, when a transport error occurs or a 503/429 response is received.
+func WithRetries(maxRetries int) Option {
+	return func(options *options) { options.MaxRetries = maxRetries }
+}
+
+// WithRetryBudget limits the retries to the given ratio of the requests sent to each service.
+// Defaults to 0.2.
+func WithRetryBudget(ratio float64) Option {
+	return func(options *options) { options.RetryBudget = ratio }
+}
+
+// WithPanicThreshold sets the panic threshold in percentage. When the percentage of the
+// healthy endpoints (pods) of a service falls below the panic threshold, the service enters
+// the panic mode, where health is ignored and requests are spread over all endpoints.
+// Zero disables the panic mode. Defaults to 50.
+func WithPanicThreshold(percentage float64) Option {
+	return func(options *options) { options.PanicThreshold = percentage }
+}
+
+// PanicModeHook is called when a service enters or leaves the panic mode.
+type PanicModeHook func(namespace string, endpointsName string, panicking bool)
+
+// WithPanicModeHook sets the hook called when a service enters or leaves the panic mode.
+func WithPanicModeHook(panicModeHook PanicModeHook) Option {
+	return func(options *options) { options.PanicModeHook = panicModeHook }
+}
+
+// WithMetricsCollector sets the collector of the metrics. See the Metric* constants for
+// the metrics collected, and Metrics for the built-in collector.
+func WithMetricsCollector(metricsCollector MetricsCollector) Option {
+	return func(options *options) { options.MetricsCollector = metricsCollector }
+}
+
+// WithLogger sets the logger for the events of endpoints resolution and watches.
+func WithLogger(logger Logger) Option {
+	return func(options *options) { options.Logger = logger }
+}
+
+// WithEndpointHeader sets the response header to carry the address of the endpoint (pod)
+// which served the request, e.g. EndpointHeader. Empty disables the header, which is
+// the default.
+func WithEndpointHeader(headerName string) Option {
+	return func(options *options) { options.EndpointHeader = headerName }
+}
+
+// WithTracer sets the tracer to start spans around endpoints resolution and picking.
+// See the Span* constants for the spans started.
+func WithTracer(tracer Tracer) Option {
+	return func(options *options) { options.Tracer = tracer }
+}
+
+// WithCloseIdleConnections makes the wrapped transports close the idle connections of the
+// underlying transports once the endpoints (pods) removed are no longer in use by the requests
+// in flight, so that the connections to them do not linger. As http.Transport can only close
+// all of its idle connections, the idle connections to the other hosts are closed as well,
+// which is process-wide for http.DefaultTransport.
+func WithCloseIdleConnections() Option {
+	return func(options *options) { options.CloseIdleConnections = true }
+}
@@ -0,0 +1,142 @@
+package kubetransport
+
+import (
+	"context"
+	"fmt"
+)
+
+func (kt *kubeTransport) pickEndpoint(ctx context.Context, target *requestTarget, excludedIPAddress string) (string, *endpointState, error) {
+	_, span := kt.options.Tracer.StartSpan(ctx, SpanPick, endpointsAttributes(target.Namespace, target.EndpointsName, Attribute{AttributeAddressCount, len(target.IPAddresses)}))
+	panicking := kt.updatePanicMode(target)
+	var affinityIPAddress string
+	if target.SessionAffinityTimeout >= 1 {
+		var ipAddress string
+		var endpointState *endpointState
+		var ok bool
+		if ipAddress, endpointState, affinityIPAddress, ok = kt.pickAffinityEndpoint(target, excludedIPAddress, panicking); ok {
+			endSpan(span, []Attribute{{AttributeIPAddress, ipAddress}}, nil)
+			return ipAddress, endpointState, nil
+		}
+	}
+	ipAddressTiers, err := kt.getIPAddressTiers(ctx, target, panicking)
+	if err != nil {
+		endSpan(span, nil, err)
+		return "", nil, err
+	}
+	var ipAddress string
+	var endpointState *endpointState
+	var ok bool
+	for _, ipAddresses := range ipAddressTiers {
+		if ipAddress, endpointState, ok = kt.pickIPAddress(ipAddresses, excludedIPAddress, panicking); ok {
+			break
+		}
+	}
+	if !ok {
+		err = fmt.Errorf("%w; namespace=%q endpointsName=%q", ErrOverloaded, target.Namespace, target.EndpointsName)
+		endSpan(span, nil, err)
+		return "", nil, err
+	}
+	if target.SessionAffinityTimeout >= 1 && affinityIPAddress == "" && !target.Partial {
+		// The endpoint stuck to has gone or the affinity has expired. An endpoint picked from
+		// a partial target, e.g. of a subset, is not stuck to, as the affinity is shared by
+		// all the requests for the service.
+		target.ServiceState.Affinity.Set(ipAddress, kt.stateRegistry.clock.Now().Add(target.SessionAffinityTimeout))
+	}
+	endSpan(span, []Attribute{{AttributeIPAddress, ipAddress}}, nil)
+	return ipAddress, endpointState, nil
+}
+
+// getIPAddressTiers returns the lists of the ip addresses of the target to pick from in
+// order, each of which is tried only if no endpoint can be picked from the previous ones.
+func (kt *kubeTransport) getIPAddressTiers(ctx context.Context, target *requestTarget, panicking bool) ([][]string, error) {
+	var ipAddressTiers [][]string
+	switch kt.nodeLocalPolicy(ctx) {
+	case NodeLocalPolicyPreferLocal:
+		if localIPAddresses := kt.filterIPAddressesByNode(target); len(localIPAddresses) >= 1 {
+			ipAddressTiers = append(ipAddressTiers, localIPAddresses)
+		}
+	case NodeLocalPolicyLocalOnly:
+		localIPAddresses := kt.filterIPAddressesByNode(target)
+		if len(localIPAddresses) == 0 {
+			return nil, fmt.Errorf("%w on node; namespace=%q endpointsName=%q nodeName=%q", ErrNoIPAddress, target.Namespace, target.EndpointsName, kt.options.NodeName)
+		}
+		return [][]string{localIPAddresses}, nil
+	}
+	if !panicking {
+		if ipAddresses := kt.filterIPAddressesByZone(target); len(ipAddresses) < len(target.IPAddresses) {
+			ipAddressTiers = append(ipAddressTiers, ipAddresses)
+		}
+	}
+	ipAddressTiers = append(ipAddressTiers, target.IPAddresses)
+	if len(target.TerminatingIPAddresses) >= 1 {
+		ipAddressTiers = append(ipAddressTiers, target.TerminatingIPAddresses)
+	}
+	return ipAddressTiers, nil
+}
+
+// updatePanicMode enters the panic mode of the service if the percentage of the healthy
+// endpoints falls below the panic threshold, or leaves it otherwise, and returns whether
+// the service is in the panic mode. The ip addresses of a partial target tell nothing about
+// the health of the service, so the panic mode is left as is. The panic mode is not checked
+// again until the health of the endpoints may have changed, see NeedsPanicCheck.
+func (kt *kubeTransport) updatePanicMode(target *requestTarget) bool {
+	panicThreshold := kt.options.PanicThreshold
+	if panicThreshold <= 0 {
+		return false
+	}
+	if target.Partial {
+		return target.ServiceState.IsPanicking()
+	}
+	// The health version is loaded before the health of the endpoints is looked at, so that
+	// any change in between causes another check.
+	healthVersion := kt.stateRegistry.HealthVersion()
+	now := kt.stateRegistry.clock.Now()
+	if !target.ServiceState.NeedsPanicCheck(healthVersion, len(target.IPAddresses), now) {
+		return target.ServiceState.IsPanicking()
+	}
+	defer target.ServiceState.RecordPanicCheck(healthVersion, len(target.IPAddresses), now)
+	var healthyCount int
+	for _, ipAddress := range target.IPAddresses {
+		if kt.stateRegistry.GetEndpointState(ipAddress).IsHealthy() {
+			healthyCount++
+		}
+	}
+	panicking := float64(healthyCount)*100 < panicThreshold*float64(len(target.IPAddresses))
+	if target.ServiceState.SetPanicking(panicking) {
+		var value float64
+		if panicking {
+			value = 1
+		}
+		kt.options.MetricsCollector.SetGauge(MetricPanicMode, endpointsLabels(target.Namespace, target.EndpointsName), value)
+		logArgs := []interface{}{"namespace", target.Namespace, "endpointsName", target.EndpointsName, "healthyCount", healthyCount, "addressCount", len(target.IPAddresses)}
+		if panicking {
+			kt.options.Logger.Warn("entering panic mode", logArgs...)
+		} else {
+			kt.options.Logger.Info("leaving panic mode", logArgs...)
+		}
+		if kt.options.PanicModeHook != nil {
+			kt.options.PanicModeHook(target.Namespace, target.EndpointsName, panicking)
+		}
+	}
+	return panicking
+}
+
+// pickIPAddress picks an ip address randomly, skipping the excluded one. Unless health is
+// ignored, the ones backing off and the ones whose circuit breakers do not allow requests
+// are also skipped.
+func (kt *kubeTransport) pickIPAddress(ipAddresses []string, excludedIPAddress string, ignoreHealth bool) (string, *endpointState, bool) {
+	x := splitmix64(&kt.seed)
+	n := len(ipAddresses)
+	i := int(x % uint64(n))
+	for j := 0; j < n; j++ {
+		ipAddress := ipAddresses[(i+j)%n]
+		if ipAddress == excludedIPAddress {
+			continue
+		}
+		endpointState := kt.stateRegistry.GetEndpointState(ipAddress)
+		if ignoreHealth || (!endpointState.IsBackingOff() && endpointState.Breaker.Allow()) {
+			return ipAddress, endpointState, true
+		}
+	}
+	return "", nil, false
+}
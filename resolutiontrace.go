@@ -0,0 +1,91 @@
+package kubetransport
+
+import (
+	"context"
+	"time"
+)
+
+// ResolutionTrace is a set of hooks to run during the resolution of the requests sent with
+// the kube- schemes, in the style of net/http/httptrace. Any particular hook may be nil.
+type ResolutionTrace struct {
+	// GotEndpoint is called when an endpoint (pod) is chosen for the request, once per
+	// attempt, so it may be called more than once with retries or hedged requests.
+	GotEndpoint func(ResolutionInfo)
+}
+
+// ResolutionInfo is the information about the resolution of a request.
+type ResolutionInfo struct {
+	Namespace     string
+	EndpointsName string
+
+	// IPAddress is the ip address of the endpoint (pod) chosen.
+	IPAddress string
+
+	// CacheHit is true if the ip addresses of the endpoints were served from the cache.
+	CacheHit bool
+
+	// WaitTime is the time taken to resolve the ip addresses of the endpoints.
+	WaitTime time.Duration
+
+	// FailedOver is true if the endpoints are of a standby service of a failover group,
+	// in which case Namespace and EndpointsName are of the standby service, and
+	// LogicalNamespace and LogicalEndpointsName are of the service requested.
+	FailedOver           bool
+	LogicalNamespace     string
+	LogicalEndpointsName string
+}
+
+type resolutionTraceKey struct{}
+
+// WithResolutionTrace returns a copy of the given context which runs the hooks of the given
+// resolution trace. If the context already has a resolution trace, the hooks of both are run,
+// the new ones first.
+func WithResolutionTrace(ctx context.Context, trace *ResolutionTrace) context.Context {
+	if oldTrace := ContextResolutionTrace(ctx); oldTrace != nil {
+		newTrace := *trace
+		if oldTrace.GotEndpoint != nil {
+			if newTrace.GotEndpoint == nil {
+				newTrace.GotEndpoint = oldTrace.GotEndpoint
+			} else {
+				gotEndpoint := trace.GotEndpoint
+				newTrace.GotEndpoint = func(resolutionInfo ResolutionInfo) {
+					gotEndpoint(resolutionInfo)
+					oldTrace.GotEndpoint(resolutionInfo)
+				}
+			}
+		}
+		trace = &newTrace
+	}
+	return context.WithValue(ctx, resolutionTraceKey{}, trace)
+}
+
+// ContextResolutionTrace returns the resolution trace associated with the given context.
+// If none, it returns nil.
+func ContextResolutionTrace(ctx context.Context) *ResolutionTrace {
+	trace, _ := ctx.Value(resolutionTraceKey{}).(*ResolutionTrace)
+	return trace
+}
+
+// EndpointHeader is the conventional name of the response header carrying the address of
+// the endpoint (pod) which served the request. See WithEndpointHeader.
+const EndpointHeader = "X-Kubetransport-Endpoint"
+
+func (kt *kubeTransport) traceGotEndpoint(ctx context.Context, target *requestTarget, ipAddress string) {
+	trace := ContextResolutionTrace(ctx)
+	if trace == nil || trace.GotEndpoint == nil {
+		return
+	}
+	resolutionInfo := ResolutionInfo{
+		Namespace:     target.Namespace,
+		EndpointsName: target.EndpointsName,
+		IPAddress:     ipAddress,
+		CacheHit:      target.CacheHit,
+		WaitTime:      target.ResolutionWaitTime,
+		FailedOver:    target.FailedOver,
+	}
+	if target.FailedOver {
+		resolutionInfo.LogicalNamespace = target.LogicalNamespace
+		resolutionInfo.LogicalEndpointsName = target.LogicalEndpointsName
+	}
+	trace.GotEndpoint(resolutionInfo)
+}
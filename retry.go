@@ -0,0 +1,94 @@
+package kubetransport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (kt *kubeTransport) doRetriableRequest(request *http.Request, target *requestTarget) (*http.Response, error) {
+	if kt.options.MaxRetries == 0 || !isRetriable(request) {
+		return kt.doRequest(request, target, "")
+	}
+	ctx := request.Context()
+	ipAddress, endpointState, err := kt.pickEndpoint(request.Context(), target, "")
+	if err != nil {
+		return nil, err
+	}
+	response, err := kt.sendRequest(request, target, ipAddress, endpointState)
+	for i := 1; i <= kt.options.MaxRetries; i++ {
+		if ctx.Err() != nil || !shouldRetry(response, err) {
+			break
+		}
+		nextIPAddress, nextEndpointState, err2 := kt.pickEndpoint(request.Context(), target, ipAddress)
+		if err2 != nil {
+			break
+		}
+		if !target.ServiceState.RetryBudget.Withdraw() {
+			nextEndpointState.Breaker.Cancel()
+			break
+		}
+		retry := request.Clone(ctx)
+		if request.GetBody != nil {
+			body, err2 := request.GetBody()
+			if err2 != nil {
+				nextEndpointState.Breaker.Cancel()
+				break
+			}
+			retry.Body = body
+		}
+		if response != nil && response.Body != nil {
+			response.Body.Close()
+		}
+		ipAddress = nextIPAddress
+		response, err = kt.sendRequest(retry, target, ipAddress, nextEndpointState)
+	}
+	return response, err
+}
+
+func isRetriable(request *http.Request) bool {
+	switch request.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return isReplayable(request)
+	default:
+		return false
+	}
+}
+
+func shouldRetry(response *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	return isRejection(response)
+}
+
+func isRejection(response *http.Response) bool {
+	return response.StatusCode == http.StatusServiceUnavailable || response.StatusCode == http.StatusTooManyRequests
+}
+
+// maxRetryAfter caps the backoff requested by a Retry-After header.
+const maxRetryAfter = 5 * time.Minute
+
+func parseRetryAfter(response *http.Response, now time.Time) (time.Duration, bool) {
+	value := response.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	var retryAfter time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		retryAfter = time.Duration(seconds) * time.Second
+	} else if t, err := http.ParseTime(value); err == nil {
+		retryAfter = t.Sub(now)
+	} else {
+		return 0, false
+	}
+	if retryAfter <= 0 {
+		return 0, false
+	}
+	if retryAfter > maxRetryAfter {
+		retryAfter = maxRetryAfter
+	}
+	return retryAfter, true
+}
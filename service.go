@@ -0,0 +1,118 @@
+package kubetransport
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-tk/kubetransport/internal/k8sclient"
+)
+
+// WithServiceLookup enables getting the Service of the endpoints along with the endpoints,
+// to honor the settings of the Service which kube-proxy would honor, i.e. sessionAffinity,
+// and to send the requests for ExternalName Services to their external names through DNS.
+// Services without selectors, whose endpoints are managed manually, work as usual.
+// This requires the permission to get and watch services. If getting a Service fails, the
+// endpoints are used as if the Service had no such settings.
+func WithServiceLookup() Option {
+	return func(options *options) { options.ServiceLookup = true }
+}
+
+// defaultSessionAffinityTimeout is the default of sessionAffinityConfig.clientIP.timeoutSeconds.
+const defaultSessionAffinityTimeout = 3 * time.Hour
+
+// GetSessionAffinityTimeout returns the session affinity timeout of the Service of the
+// endpoints, or zero if ClientIP session affinity is not set or the Service is unknown.
+func (er *endpointsRegistry) GetSessionAffinityTimeout(namespace string, endpointsName string) time.Duration {
+	service := er.getService(namespace, endpointsName)
+	if service == nil || service.Spec.SessionAffinity != k8sclient.SessionAffinityClientIP {
+		return 0
+	}
+	if sessionAffinityConfig := service.Spec.SessionAffinityConfig; sessionAffinityConfig != nil &&
+		sessionAffinityConfig.ClientIP != nil && sessionAffinityConfig.ClientIP.TimeoutSeconds != nil {
+		return time.Duration(*sessionAffinityConfig.ClientIP.TimeoutSeconds) * time.Second
+	}
+	return defaultSessionAffinityTimeout
+}
+
+// GetExternalName returns the external name of the Service of the endpoints, or an empty
+// string if the Service is not of type ExternalName or is unknown.
+func (er *endpointsRegistry) GetExternalName(namespace string, endpointsName string) string {
+	service := er.getService(namespace, endpointsName)
+	if service == nil || service.Spec.Type != k8sclient.ServiceTypeExternalName {
+		return ""
+	}
+	return service.Spec.ExternalName
+}
+
+func (er *endpointsRegistry) getService(namespace string, endpointsName string) *k8sclient.Service {
+	if namespace == "" {
+		namespace = er.k8sClient.Namespace()
+	}
+	value, ok := er.ipAddressesCache.Load(endpointKey{namespace, endpointsName})
+	if !ok {
+		return nil
+	}
+	cachedIPAddresses, ok := value.(*cachedIPAddresses)
+	if !ok {
+		return nil
+	}
+	return cachedIPAddresses.Source.Service()
+}
+
+// sessionAffinity keeps the endpoint (pod) a client instance sticks to, like the ClientIP
+// session affinity of kube-proxy, where all the requests of the client instance come from
+// the same ip address.
+type sessionAffinity struct {
+	lock       sync.Mutex
+	ipAddress  string
+	expiryTime time.Time
+}
+
+// Get returns the ip address stuck to, or an empty string if none or expired.
+func (sa *sessionAffinity) Get(now time.Time) string {
+	sa.lock.Lock()
+	defer sa.lock.Unlock()
+	if !now.Before(sa.expiryTime) {
+		return ""
+	}
+	return sa.ipAddress
+}
+
+// Set sticks to the given ip address until the given time.
+func (sa *sessionAffinity) Set(ipAddress string, expiryTime time.Time) {
+	sa.lock.Lock()
+	sa.ipAddress = ipAddress
+	sa.expiryTime = expiryTime
+	sa.lock.Unlock()
+}
+
+// pickAffinityEndpoint picks the endpoint the service sticks to if it is still available.
+// Otherwise it returns false, along with the ip address stuck to, if any. The affinity is
+// only renewed for targets which are not partial.
+func (kt *kubeTransport) pickAffinityEndpoint(target *requestTarget, excludedIPAddress string, ignoreHealth bool) (string, *endpointState, string, bool) {
+	now := kt.stateRegistry.clock.Now()
+	ipAddress := target.ServiceState.Affinity.Get(now)
+	if ipAddress == "" || !containsIPAddress(target.IPAddresses, ipAddress) {
+		return "", nil, "", false
+	}
+	if ipAddress == excludedIPAddress {
+		return "", nil, ipAddress, false
+	}
+	endpointState := kt.stateRegistry.GetEndpointState(ipAddress)
+	if !ignoreHealth && (endpointState.IsBackingOff() || !endpointState.Breaker.Allow()) {
+		return "", nil, ipAddress, false
+	}
+	if !target.Partial {
+		target.ServiceState.Affinity.Set(ipAddress, now.Add(target.SessionAffinityTimeout))
+	}
+	return ipAddress, endpointState, ipAddress, true
+}
+
+func containsIPAddress(ipAddresses []string, ipAddress string) bool {
+	for _, ipAddress2 := range ipAddresses {
+		if ipAddress2 == ipAddress {
+			return true
+		}
+	}
+	return false
+}
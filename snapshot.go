@@ -0,0 +1,176 @@
+package kubetransport
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Snapshot is a snapshot of the endpoints of a service.
+type Snapshot struct {
+	Namespace     string `json:"namespace"`
+	EndpointsName string `json:"endpointsName"`
+
+	// IPAddresses is nil if the endpoints are not found.
+	IPAddresses []string `json:"ipAddresses"`
+
+	ResourceVersion string `json:"resourceVersion"`
+
+	// HitCount is the number of cache hits since the last eviction check, which happens
+	// every minute.
+	HitCount int64 `json:"hitCount"`
+
+	// LastUpdateTime is the time the endpoints were last received.
+	LastUpdateTime time.Time `json:"lastUpdateTime"`
+
+	WatchState        WatchState `json:"watchState"`
+	WatchFailureCount int        `json:"watchFailureCount"`
+
+	// History is the recent history of the endpoints, from the oldest to the latest.
+	History []HistoryEntry `json:"history"`
+}
+
+// WatchState is the state of the watch of endpoints.
+type WatchState string
+
+const (
+	// WatchStatePending means the endpoints are being got for the first time.
+	WatchStatePending = WatchState("pending")
+
+	// WatchStateWatching means the endpoints are being watched.
+	WatchStateWatching = WatchState("watching")
+
+	// WatchStateStopped means the watch has been stopped because of eviction.
+	WatchStateStopped = WatchState("stopped")
+
+	// WatchStateFailed means the watch has failed and will be restarted on demand.
+	WatchStateFailed = WatchState("failed")
+)
+
+// Snapshot returns the snapshots of all the endpoints known, sorted by namespace and name.
+func (tw *TransportWrapper) Snapshot() []Snapshot { return tw.endpointsRegistry.Snapshot() }
+
+func (er *endpointsRegistry) Snapshot() []Snapshot {
+	var snapshots []Snapshot
+	seenEndpointKeys := make(map[endpointKey]struct{})
+	er.ipAddressesCache.Range(func(key, value interface{}) bool {
+		endpointKey := key.(endpointKey)
+		seenEndpointKeys[endpointKey] = struct{}{}
+		switch value := value.(type) {
+		case *getIPAddressesResults:
+			snapshots = append(snapshots, Snapshot{
+				Namespace:         endpointKey.Namespace,
+				EndpointsName:     endpointKey.EndpointsName,
+				WatchState:        WatchStatePending,
+				WatchFailureCount: er.watchFailureCount(endpointKey),
+				History:           er.History(endpointKey.Namespace, endpointKey.EndpointsName),
+			})
+		case *cachedIPAddresses:
+			snapshots = append(snapshots, value.Snapshot(endpointKey))
+		default:
+			panic("unreachable code")
+		}
+		return true
+	})
+	er.watchFailures.Range(func(key, value interface{}) bool {
+		endpointKey := key.(endpointKey)
+		if _, ok := seenEndpointKeys[endpointKey]; ok {
+			return true
+		}
+		snapshots = append(snapshots, Snapshot{
+			Namespace:         endpointKey.Namespace,
+			EndpointsName:     endpointKey.EndpointsName,
+			WatchState:        WatchStateFailed,
+			WatchFailureCount: value.(*watchFailure).Count,
+			History:           er.History(endpointKey.Namespace, endpointKey.EndpointsName),
+		})
+		return true
+	})
+	sort.Slice(snapshots, func(i, j int) bool {
+		if snapshots[i].Namespace != snapshots[j].Namespace {
+			return snapshots[i].Namespace < snapshots[j].Namespace
+		}
+		return snapshots[i].EndpointsName < snapshots[j].EndpointsName
+	})
+	return snapshots
+}
+
+func (er *endpointsRegistry) watchFailureCount(endpointKey endpointKey) int {
+	if value, ok := er.watchFailures.Load(endpointKey); ok {
+		return value.(*watchFailure).Count
+	}
+	return 0
+}
+
+func (cia *cachedIPAddresses) Snapshot(endpointKey endpointKey) Snapshot {
+	watchState := WatchStateWatching
+	if cia.Source.IsStopped() {
+		watchState = WatchStateStopped
+	}
+	return Snapshot{
+		Namespace:       endpointKey.Namespace,
+		EndpointsName:   endpointKey.EndpointsName,
+		IPAddresses:     copyIPAddresses(cia.Value),
+		ResourceVersion: cia.ResourceVersion,
+		HitCount:        atomic.LoadInt64(&cia.HitCount),
+		LastUpdateTime:  cia.UpdateTime,
+		WatchState:      watchState,
+		History:         cia.Source.eventHistory.Entries(),
+	}
+}
+
+// copyIPAddresses returns a copy of the given ip addresses, so that the ip addresses in the
+// cache are not exposed to modifications. Nil is kept as is, which means not found.
+func copyIPAddresses(ipAddresses []string) []string {
+	if ipAddresses == nil {
+		return nil
+	}
+	return append(make([]string, 0, len(ipAddresses)), ipAddresses...)
+}
+
+// DebugHandler returns an http.Handler serving the snapshots of all the endpoints known,
+// intended to be mounted under /debug/kubetransport. The snapshots are rendered as JSON
+// if the query parameter format=json is given or the request accepts application/json,
+// otherwise as HTML.
+func (tw *TransportWrapper) DebugHandler() http.Handler {
+	return debugHandler{tw.endpointsRegistry}
+}
+
+type debugHandler struct {
+	endpointsRegistry *endpointsRegistry
+}
+
+func (dh debugHandler) ServeHTTP(responseWriter http.ResponseWriter, request *http.Request) {
+	snapshots := dh.endpointsRegistry.Snapshot()
+	if request.URL.Query().Get("format") == "json" || strings.Contains(request.Header.Get("Accept"), "application/json") {
+		responseWriter.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if snapshots == nil {
+			snapshots = []Snapshot{}
+		}
+		encoder := json.NewEncoder(responseWriter)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(snapshots)
+		return
+	}
+	responseWriter.Header().Set("Content-Type", "text/html; charset=utf-8")
+	debugPageTemplate.Execute(responseWriter, snapshots)
+}
+
+var debugPageTemplate = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html>
+<head><title>kubetransport</title></head>
+<body>
+<h1>kubetransport</h1>
+<table border="1" cellpadding="4">
+<tr><th>Namespace</th><th>Endpoints Name</th><th>IP Addresses</th><th>Resource Version</th><th>Hit Count</th><th>Last Update Time</th><th>Watch State</th><th>Watch Failure Count</th><th>History</th></tr>
+{{- range .}}
+<tr><td>{{.Namespace}}</td><td>{{.EndpointsName}}</td><td>{{range $i, $ipAddress := .IPAddresses}}{{if $i}}<br>{{end}}{{$ipAddress}}{{end}}</td><td>{{.ResourceVersion}}</td><td>{{.HitCount}}</td><td>{{if not .LastUpdateTime.IsZero}}{{.LastUpdateTime.Format "2006-01-02T15:04:05.000Z07:00"}}{{end}}</td><td>{{.WatchState}}</td><td>{{.WatchFailureCount}}</td><td>{{range $i, $entry := .History}}{{if $i}}<br>{{end}}{{$entry.Time.Format "15:04:05.000"}} {{$entry.EventType}} {{$entry.ResourceVersion}}{{range $entry.AddedIPAddresses}} +{{.}}{{end}}{{range $entry.RemovedIPAddresses}} -{{.}}{{end}}{{with $entry.Error}} {{.}}{{end}}{{end}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
@@ -0,0 +1,118 @@
+package kubetransport_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/go-tk/kubetransport"
+	"github.com/go-tk/kubetransport/internal/k8sclient"
+	mock_k8sclient "github.com/go-tk/kubetransport/internal/k8sclient/mock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpointsRegistry_Snapshot(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	mockK8sClient := mock_k8sclient.NewMockK8sClient(ctrl)
+	mockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("foo"), gomock.Eq("bar")).
+		DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+			return &k8sclient.Endpoints{
+				Metadata: k8sclient.Metadata{ResourceVersion: "8910"},
+				Subsets: []k8sclient.EndpointSubset{
+					{Addresses: []k8sclient.EndpointAddress{{IP: "1.2.3.4"}, {IP: "2.3.4.5"}}},
+				},
+			}, nil
+		})
+	mockK8sClient.EXPECT().WatchEndpoints(gomock.Any(), gomock.Eq("foo"), gomock.Eq("bar"), gomock.Eq("8910"), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, namespace, endpointsName, resourceVersion string, callback k8sclient.WatchEndpointsCallback) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}).MinTimes(0)
+	mockK8sClient.EXPECT().GetEndpoints(gomock.Any(), gomock.Eq("foo"), gomock.Eq("baz")).
+		DoAndReturn(func(ctx context.Context, namespace, endpointsName string) (*k8sclient.Endpoints, error) {
+			return nil, errors.New("something wrong")
+		})
+	options := MakeOptions(nil)
+	er := NewEndpointsRegistry(context.Background(), mockK8sClient, &options, 24*time.Hour)
+	t.Cleanup(er.Stop)
+	_, err := er.GetIPAddresses(context.Background(), "foo", "bar")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, err = er.GetIPAddresses(context.Background(), "foo", "bar")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, err = er.GetIPAddresses(context.Background(), "foo", "baz")
+	if !assert.Error(t, err) {
+		t.FailNow()
+	}
+
+	snapshots := er.Snapshot()
+	if !assert.Len(t, snapshots, 2) {
+		t.FailNow()
+	}
+	assert.False(t, snapshots[0].LastUpdateTime.IsZero())
+	snapshots[0].LastUpdateTime = time.Time{}
+	for i := range snapshots {
+		for j := range snapshots[i].History {
+			snapshots[i].History[j].Time = time.Time{}
+		}
+	}
+	assert.Equal(t, []Snapshot{
+		{
+			Namespace:       "foo",
+			EndpointsName:   "bar",
+			IPAddresses:     []string{"1.2.3.4", "2.3.4.5"},
+			ResourceVersion: "8910",
+			HitCount:        2,
+			WatchState:      WatchStateWatching,
+			History: []HistoryEntry{
+				{EventType: "GET", ResourceVersion: "8910", AddedIPAddresses: []string{"1.2.3.4", "2.3.4.5"}},
+			},
+		},
+		{
+			Namespace:         "foo",
+			EndpointsName:     "baz",
+			WatchState:        WatchStateFailed,
+			WatchFailureCount: 1,
+			History: []HistoryEntry{
+				{EventType: "ERROR", Error: "something wrong"},
+			},
+		},
+	}, snapshots)
+
+	snapshots[0].IPAddresses[0] = "9.9.9.9"
+	ipAddresses, err := er.GetIPAddresses(context.Background(), "foo", "bar")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"1.2.3.4", "2.3.4.5"}, ipAddresses)
+	}
+
+	debugHandler := NewDebugHandler(er)
+	responseRecorder := httptest.NewRecorder()
+	debugHandler.ServeHTTP(responseRecorder, httptest.NewRequest(http.MethodGet, "/debug/kubetransport?format=json", nil))
+	assert.Equal(t, "application/json; charset=utf-8", responseRecorder.Header().Get("Content-Type"))
+	var jsonSnapshots []Snapshot
+	if !assert.NoError(t, json.Unmarshal(responseRecorder.Body.Bytes(), &jsonSnapshots)) {
+		t.FailNow()
+	}
+	if assert.Len(t, jsonSnapshots, 2) {
+		assert.Equal(t, []string{"1.2.3.4", "2.3.4.5"}, jsonSnapshots[0].IPAddresses)
+		assert.Equal(t, WatchStateFailed, jsonSnapshots[1].WatchState)
+	}
+
+	responseRecorder = httptest.NewRecorder()
+	debugHandler.ServeHTTP(responseRecorder, httptest.NewRequest(http.MethodGet, "/debug/kubetransport", nil))
+	assert.Equal(t, "text/html; charset=utf-8", responseRecorder.Header().Get("Content-Type"))
+	body := responseRecorder.Body.String()
+	assert.True(t, strings.Contains(body, "<td>1.2.3.4<br>2.3.4.5</td>"), body)
+	assert.True(t, strings.Contains(body, "<td>baz</td>"), body)
+	assert.True(t, strings.Contains(body, " GET 8910 +1.2.3.4 +2.3.4.5</td>"), body)
+}
@@ -0,0 +1,365 @@
+package kubetransport
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/benbjohnson/clock"
+)
+
+type stateRegistry struct {
+	options        *options
+	clock          clock.Clock
+	endpointStates sync.Map
+	serviceStates  sync.Map
+
+	// healthVersion is incremented every time an endpoint becomes unhealthy, or healthy
+	// again by its circuit breaker, see HealthVersion.
+	healthVersion int64
+}
+
+func newStateRegistry(options *options, clock clock.Clock) *stateRegistry {
+	var sr stateRegistry
+	sr.options = options
+	sr.clock = clock
+	return &sr
+}
+
+func (sr *stateRegistry) GetEndpointState(ipAddress string) *endpointState {
+	if value, ok := sr.endpointStates.Load(ipAddress); ok {
+		return value.(*endpointState)
+	}
+	value, _ := sr.endpointStates.LoadOrStore(ipAddress, &endpointState{
+		Limiter: newLimiter(sr.options.EndpointLimits),
+		Breaker: newCircuitBreaker(sr.options.CircuitBreaker, sr.clock, &sr.healthVersion),
+		clock:   sr.clock,
+
+		healthVersion: &sr.healthVersion,
+	})
+	return value.(*endpointState)
+}
+
+// HealthVersion returns a number which changes whenever any endpoint becomes unhealthy, by
+// backing off or by its circuit breaker, or healthy again by its circuit breaker. Endpoints
+// becoming healthy again when they stop backing off do not change it.
+func (sr *stateRegistry) HealthVersion() int64 { return atomic.LoadInt64(&sr.healthVersion) }
+
+func (sr *stateRegistry) GetServiceState(namespace string, endpointsName string) *serviceState {
+	endpointKey := endpointKey{namespace, endpointsName}
+	if value, ok := sr.serviceStates.Load(endpointKey); ok {
+		return value.(*serviceState)
+	}
+	value, _ := sr.serviceStates.LoadOrStore(endpointKey, &serviceState{
+		Limiter:       newLimiter(sr.options.ServiceLimits),
+		HedgingBudget: newTokenBucket(sr.options.HedgingBudget),
+		RetryBudget:   newTokenBucket(sr.options.RetryBudget),
+		Latencies:     new(latencyWindow),
+		Stats:         newServiceStats(sr.clock),
+	})
+	return value.(*serviceState)
+}
+
+// RemoveEndpointStates removes the stats of the endpoints with the given ip addresses from
+// the given service, and the states of those endpoints not in use by other services, as
+// reported by isInUse, which would otherwise be reset, e.g. their limiters.
+func (sr *stateRegistry) RemoveEndpointStates(namespace string, endpointsName string, ipAddresses []string, isInUse func(ipAddress string) bool) {
+	for _, ipAddress := range ipAddresses {
+		if !isInUse(ipAddress) {
+			sr.endpointStates.Delete(ipAddress)
+		}
+	}
+	if value, ok := sr.serviceStates.Load(endpointKey{namespace, endpointsName}); ok {
+		value.(*serviceState).Stats.Remove(ipAddresses)
+	}
+}
+
+type endpointState struct {
+	Limiter *limiter
+	Breaker *circuitBreaker
+
+	clock         clock.Clock
+	healthVersion *int64
+	backOffUntil  int64
+}
+
+// BackOff stops picking the endpoint for the given duration.
+func (es *endpointState) BackOff(duration time.Duration) {
+	atomic.StoreInt64(&es.backOffUntil, es.clock.Now().Add(duration).UnixNano())
+	atomic.AddInt64(es.healthVersion, 1)
+}
+
+// IsHealthy reports whether the endpoint is neither backing off nor ejected by the
+// circuit breaker.
+func (es *endpointState) IsHealthy() bool {
+	return !es.IsBackingOff() && es.Breaker.State() == circuitBreakerClosed
+}
+
+func (es *endpointState) IsBackingOff() bool {
+	backOffUntil := atomic.LoadInt64(&es.backOffUntil)
+	return backOffUntil != 0 && es.clock.Now().UnixNano() < backOffUntil
+}
+
+type serviceState struct {
+	Limiter       *limiter
+	HedgingBudget *tokenBucket
+	RetryBudget   *tokenBucket
+	Latencies     *latencyWindow
+	Stats         *serviceStats
+	Affinity      sessionAffinity
+
+	panicking  int32
+	panicCheck atomic.Value
+}
+
+// panicCheckInterval is the interval to reuse the last check of the panic mode of a service
+// for, as long as neither the health version nor the number of the endpoints changes, so
+// that the health of every endpoint is not looked at on every pick. It bounds how long
+// endpoints which stop backing off, or which are replaced, take to be noticed.
+const panicCheckInterval = 1 * time.Second
+
+type panicCheck struct {
+	HealthVersion int64
+	AddressCount  int
+	Time          time.Time
+}
+
+// NeedsPanicCheck reports whether the panic mode of the service should be checked again,
+// given the current health version, the number of the endpoints and the time.
+func (ss *serviceState) NeedsPanicCheck(healthVersion int64, addressCount int, now time.Time) bool {
+	lastPanicCheck, ok := ss.panicCheck.Load().(panicCheck)
+	return !ok || lastPanicCheck.HealthVersion != healthVersion || lastPanicCheck.AddressCount != addressCount ||
+		now.Sub(lastPanicCheck.Time) >= panicCheckInterval
+}
+
+// RecordPanicCheck records a check of the panic mode of the service, see NeedsPanicCheck.
+func (ss *serviceState) RecordPanicCheck(healthVersion int64, addressCount int, now time.Time) {
+	ss.panicCheck.Store(panicCheck{healthVersion, addressCount, now})
+}
+
+// SetPanicking sets whether the service is in the panic mode, and returns true if
+// it is changed.
+func (ss *serviceState) SetPanicking(panicking bool) bool {
+	var value int32
+	if panicking {
+		value = 1
+	}
+	return atomic.SwapInt32(&ss.panicking, value) != value
+}
+
+// IsPanicking returns whether the service is in the panic mode.
+func (ss *serviceState) IsPanicking() bool { return atomic.LoadInt32(&ss.panicking) == 1 }
+
+type limiter struct {
+	slots              chan struct{}
+	maxPendingRequests int64
+	pendingRequests    int64
+}
+
+func newLimiter(limits Limits) *limiter {
+	var l limiter
+	if limits.MaxConcurrentRequests >= 1 {
+		l.slots = make(chan struct{}, limits.MaxConcurrentRequests)
+		l.maxPendingRequests = int64(limits.MaxPendingRequests)
+	}
+	return &l
+}
+
+func (l *limiter) Acquire(ctx context.Context) error {
+	if l.slots == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if atomic.AddInt64(&l.pendingRequests, 1) > l.maxPendingRequests {
+		atomic.AddInt64(&l.pendingRequests, -1)
+		return ErrOverloaded
+	}
+	defer atomic.AddInt64(&l.pendingRequests, -1)
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *limiter) Release() {
+	if l.slots == nil {
+		return
+	}
+	<-l.slots
+}
+
+// tokenBucket gains the given ratio of a token from each deposit, and each withdrawal
+// costs one token.
+type tokenBucket struct {
+	ratio float64
+
+	lock   sync.Mutex
+	tokens float64
+}
+
+const maxTokenBucketTokens = 10
+
+func newTokenBucket(ratio float64) *tokenBucket {
+	var tb tokenBucket
+	tb.ratio = ratio
+	return &tb
+}
+
+func (tb *tokenBucket) Deposit() {
+	tb.lock.Lock()
+	if tb.tokens += tb.ratio; tb.tokens > maxTokenBucketTokens {
+		tb.tokens = maxTokenBucketTokens
+	}
+	tb.lock.Unlock()
+}
+
+func (tb *tokenBucket) Withdraw() bool {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+	if tb.tokens < 1 {
+		return false
+	}
+	tb.tokens--
+	return true
+}
+
+// latencyWindow keeps the latest latencies.
+type latencyWindow struct {
+	lock      sync.Mutex
+	latencies [256]time.Duration
+	n         int
+}
+
+// minLatencySamples is the minimum number of latencies required to compute percentiles.
+const minLatencySamples = 16
+
+func (lw *latencyWindow) Add(latency time.Duration) {
+	lw.lock.Lock()
+	lw.latencies[lw.n%len(lw.latencies)] = latency
+	lw.n++
+	lw.lock.Unlock()
+}
+
+func (lw *latencyWindow) Percentile(p float64) (time.Duration, bool) {
+	lw.lock.Lock()
+	n := lw.n
+	if n > len(lw.latencies) {
+		n = len(lw.latencies)
+	}
+	latencies := make([]time.Duration, n)
+	copy(latencies, lw.latencies[:n])
+	lw.lock.Unlock()
+	if n < minLatencySamples {
+		return 0, false
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return latencies[int(p*float64(n-1))], true
+}
+
+type circuitBreakerState int32
+
+const (
+	circuitBreakerClosed circuitBreakerState = iota
+	circuitBreakerOpen
+	circuitBreakerHalfOpen
+)
+
+type circuitBreaker struct {
+	consecutiveFailures int
+	openDuration        time.Duration
+	clock               clock.Clock
+	healthVersion       *int64
+
+	lock sync.Mutex
+	// state is only changed with the lock held, but it is also loaded without the lock, see
+	// State.
+	state                   circuitBreakerState
+	consecutiveFailureCount int
+	openUntil               time.Time
+}
+
+func newCircuitBreaker(options CircuitBreaker, clock clock.Clock, healthVersion *int64) *circuitBreaker {
+	var cb circuitBreaker
+	cb.consecutiveFailures = options.ConsecutiveFailures
+	cb.openDuration = options.OpenDuration
+	cb.clock = clock
+	cb.healthVersion = healthVersion
+	return &cb
+}
+
+// Allow reports whether a request can be sent. When the open duration has elapsed,
+// the first caller is allowed to send a probe request and the circuit breaker becomes
+// half-open until the probe request is reported or canceled.
+func (cb *circuitBreaker) Allow() bool {
+	if cb.consecutiveFailures == 0 {
+		return true
+	}
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	switch cb.state {
+	case circuitBreakerClosed:
+		return true
+	case circuitBreakerOpen:
+		if cb.clock.Now().Before(cb.openUntil) {
+			return false
+		}
+		cb.setState(circuitBreakerHalfOpen)
+		return true
+	default:
+		return false
+	}
+}
+
+func (cb *circuitBreaker) Report(ok bool) {
+	if cb.consecutiveFailures == 0 {
+		return
+	}
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	if ok {
+		cb.setState(circuitBreakerClosed)
+		cb.consecutiveFailureCount = 0
+		return
+	}
+	cb.consecutiveFailureCount++
+	if cb.state == circuitBreakerHalfOpen || cb.consecutiveFailureCount >= cb.consecutiveFailures {
+		cb.setState(circuitBreakerOpen)
+		cb.openUntil = cb.clock.Now().Add(cb.openDuration)
+	}
+}
+
+// Cancel gives up the request allowed, so that another request can be the probe
+// request if the circuit breaker is half-open.
+func (cb *circuitBreaker) Cancel() {
+	if cb.consecutiveFailures == 0 {
+		return
+	}
+	cb.lock.Lock()
+	if cb.state == circuitBreakerHalfOpen {
+		cb.setState(circuitBreakerOpen)
+	}
+	cb.lock.Unlock()
+}
+
+// State returns the state without taking the lock, as it is looked at for every endpoint of
+// a service on picks, see IsHealthy.
+func (cb *circuitBreaker) State() circuitBreakerState {
+	return circuitBreakerState(atomic.LoadInt32((*int32)(&cb.state)))
+}
+
+// setState sets the state with the lock held.
+func (cb *circuitBreaker) setState(state circuitBreakerState) {
+	oldState := cb.state
+	atomic.StoreInt32((*int32)(&cb.state), int32(state))
+	if (oldState == circuitBreakerClosed) != (state == circuitBreakerClosed) {
+		atomic.AddInt64(cb.healthVersion, 1)
+	}
+}
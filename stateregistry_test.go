@@ -0,0 +1,131 @@
+package kubetransport_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	. "github.com/go-tk/kubetransport"
+	"github.com/go-tk/testcase"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateRegistry_GetEndpointState(t *testing.T) {
+	type Workspace struct {
+		Init struct {
+			Options   Options
+			MockClock *clock.Mock
+		}
+		ExpOut, ActOut struct {
+			Results []interface{}
+		}
+
+		SR *StateRegistry
+	}
+	tc := testcase.New().
+		Step(0, func(t *testing.T, w *Workspace) {
+			w.Init.MockClock = clock.NewMock()
+		}).
+		Step(1, func(t *testing.T, w *Workspace) {
+			w.SR = NewStateRegistry(&w.Init.Options, w.Init.MockClock)
+		}).
+		Step(3, func(t *testing.T, w *Workspace) {
+			assert.Equal(t, w.ExpOut, w.ActOut)
+		})
+	testcase.RunListParallel(t,
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.Options.CircuitBreaker = CircuitBreaker{ConsecutiveFailures: 2, OpenDuration: time.Minute}
+			}).
+			Step(2, func(t *testing.T, w *Workspace) {
+				breaker := w.SR.GetEndpointState("1.2.3.4").Breaker
+				record := func() { w.ActOut.Results = append(w.ActOut.Results, breaker.Allow(), breaker.State()) }
+				breaker.Report(false)
+				record()
+				breaker.Report(false)
+				record()
+				w.Init.MockClock.Add(time.Minute)
+				record()
+				record()
+				breaker.Report(false)
+				record()
+				w.Init.MockClock.Add(time.Minute)
+				record()
+				breaker.Cancel()
+				record()
+				breaker.Report(true)
+				record()
+				w.ExpOut.Results = []interface{}{
+					true, CircuitBreakerClosed,
+					false, CircuitBreakerOpen,
+					true, CircuitBreakerHalfOpen,
+					false, CircuitBreakerHalfOpen,
+					false, CircuitBreakerOpen,
+					true, CircuitBreakerHalfOpen,
+					true, CircuitBreakerHalfOpen,
+					true, CircuitBreakerClosed,
+				}
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.Options.EndpointLimits = Limits{MaxConcurrentRequests: 1, MaxPendingRequests: 1}
+			}).
+			Step(2, func(t *testing.T, w *Workspace) {
+				limiter := w.SR.GetEndpointState("1.2.3.4").Limiter
+				w.ActOut.Results = append(w.ActOut.Results, limiter.Acquire(context.Background()))
+				errs := make(chan error)
+				go func() { errs <- limiter.Acquire(context.Background()) }()
+				time.Sleep(100 * time.Millisecond)
+				w.ActOut.Results = append(w.ActOut.Results, limiter.Acquire(context.Background()))
+				limiter.Release()
+				w.ActOut.Results = append(w.ActOut.Results, <-errs)
+				ctx, cancel := context.WithTimeout(context.Background(), 0)
+				defer cancel()
+				w.ActOut.Results = append(w.ActOut.Results, limiter.Acquire(ctx))
+				w.ExpOut.Results = []interface{}{nil, ErrOverloaded, nil, context.DeadlineExceeded}
+			}),
+		tc.Copy().
+			Step(0.5, func(t *testing.T, w *Workspace) {
+				w.Init.Options.EndpointLimits = Limits{MaxConcurrentRequests: 1}
+			}).
+			Step(2, func(t *testing.T, w *Workspace) {
+				limiter := w.SR.GetEndpointState("1.2.3.4").Limiter
+				w.ActOut.Results = append(w.ActOut.Results, limiter.Acquire(context.Background()))
+				// Still in use by another service.
+				w.SR.RemoveEndpointStates("foo", "bar", []string{"1.2.3.4"}, func(string) bool { return true })
+				w.ActOut.Results = append(w.ActOut.Results, w.SR.GetEndpointState("1.2.3.4").Limiter.Acquire(context.Background()))
+				w.SR.RemoveEndpointStates("foo", "baz", []string{"1.2.3.4"}, func(string) bool { return false })
+				w.ActOut.Results = append(w.ActOut.Results, w.SR.GetEndpointState("1.2.3.4").Limiter.Acquire(context.Background()))
+				w.ExpOut.Results = []interface{}{nil, ErrOverloaded, nil}
+			}),
+	)
+}
+
+func TestServiceState_NeedsPanicCheck(t *testing.T) {
+	options := MakeOptions(nil)
+	options.CircuitBreaker = CircuitBreaker{ConsecutiveFailures: 1, OpenDuration: time.Minute}
+	mockClock := clock.NewMock()
+	sr := NewStateRegistry(&options, mockClock)
+	serviceState := sr.GetServiceState("foo", "bar")
+	check := func(addressCount int) bool {
+		if !serviceState.NeedsPanicCheck(sr.HealthVersion(), addressCount, mockClock.Now()) {
+			return false
+		}
+		serviceState.RecordPanicCheck(sr.HealthVersion(), addressCount, mockClock.Now())
+		return true
+	}
+	assert.True(t, check(2))
+	assert.False(t, check(2))
+	assert.True(t, check(3))
+	sr.GetEndpointState("1.2.3.4").BackOff(time.Second)
+	assert.True(t, check(3))
+	assert.False(t, check(3))
+	sr.GetEndpointState("2.3.4.5").Breaker.Report(false)
+	assert.True(t, check(3))
+	sr.GetEndpointState("2.3.4.5").Breaker.Report(false)
+	assert.False(t, check(3))
+	mockClock.Add(time.Second)
+	assert.True(t, check(3))
+	assert.False(t, check(3))
+}
@@ -0,0 +1,216 @@
+package kubetransport
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+)
+
+// ServiceStats is the request statistics of a service.
+type ServiceStats struct {
+	Namespace     string
+	EndpointsName string
+
+	// Endpoints are the statistics of the endpoints (pods), sorted by ip address.
+	Endpoints []EndpointStats
+}
+
+// EndpointStats is the request statistics of an endpoint (pod) within the last StatsWindow.
+type EndpointStats struct {
+	IPAddress string
+
+	Requests int64
+
+	// Errors is the number of requests failed with transport errors or 5xx responses.
+	Errors int64
+
+	// InFlightRequests is the number of requests in flight currently.
+	InFlightRequests int64
+
+	// P50, P90 and P99 are the percentiles of the latencies of the requests, or zero if
+	// there is no request.
+	P50, P90, P99 time.Duration
+}
+
+// StatsWindow is the window of the request statistics.
+const StatsWindow = 1 * time.Minute
+
+const (
+	statsBucketCount    = 6
+	statsBucketDuration = StatsWindow / statsBucketCount
+	maxStatsLatencies   = 256
+)
+
+// Stats returns the request statistics of the services the requests have been sent to,
+// sorted by namespace and name.
+func (tw *TransportWrapper) Stats() []ServiceStats { return tw.stateRegistry.Stats() }
+
+func (sr *stateRegistry) Stats() []ServiceStats {
+	var serviceStatsList []ServiceStats
+	sr.serviceStates.Range(func(key, value interface{}) bool {
+		endpointKey := key.(endpointKey)
+		endpointStatsList := value.(*serviceState).Stats.Snapshot()
+		if len(endpointStatsList) == 0 {
+			return true
+		}
+		serviceStatsList = append(serviceStatsList, ServiceStats{
+			Namespace:     endpointKey.Namespace,
+			EndpointsName: endpointKey.EndpointsName,
+			Endpoints:     endpointStatsList,
+		})
+		return true
+	})
+	sort.Slice(serviceStatsList, func(i, j int) bool {
+		if serviceStatsList[i].Namespace != serviceStatsList[j].Namespace {
+			return serviceStatsList[i].Namespace < serviceStatsList[j].Namespace
+		}
+		return serviceStatsList[i].EndpointsName < serviceStatsList[j].EndpointsName
+	})
+	return serviceStatsList
+}
+
+// serviceStats keeps the request statistics of the endpoints of a service.
+type serviceStats struct {
+	clock clock.Clock
+
+	lock          sync.Mutex
+	endpointStats map[string]*endpointStats
+}
+
+func newServiceStats(clock clock.Clock) *serviceStats {
+	var ss serviceStats
+	ss.clock = clock
+	ss.endpointStats = make(map[string]*endpointStats)
+	return &ss
+}
+
+func (ss *serviceStats) Get(ipAddress string) *endpointStats {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	endpointStats, ok := ss.endpointStats[ipAddress]
+	if !ok {
+		endpointStats = newEndpointStats(ss.clock)
+		ss.endpointStats[ipAddress] = endpointStats
+	}
+	return endpointStats
+}
+
+func (ss *serviceStats) Remove(ipAddresses []string) {
+	ss.lock.Lock()
+	for _, ipAddress := range ipAddresses {
+		delete(ss.endpointStats, ipAddress)
+	}
+	ss.lock.Unlock()
+}
+
+func (ss *serviceStats) Snapshot() []EndpointStats {
+	ss.lock.Lock()
+	endpointStatsList := make([]EndpointStats, 0, len(ss.endpointStats))
+	for ipAddress, endpointStats := range ss.endpointStats {
+		endpointStatsList = append(endpointStatsList, endpointStats.Snapshot(ipAddress))
+	}
+	ss.lock.Unlock()
+	sort.Slice(endpointStatsList, func(i, j int) bool { return endpointStatsList[i].IPAddress < endpointStatsList[j].IPAddress })
+	return endpointStatsList
+}
+
+// endpointStats keeps the request statistics of an endpoint in time buckets, which together
+// cover StatsWindow.
+type endpointStats struct {
+	clock clock.Clock
+
+	lock             sync.Mutex
+	buckets          [statsBucketCount]statsBucket
+	inFlightRequests int64
+	latencies        [maxStatsLatencies]latencySample
+	latencyCount     int
+}
+
+type statsBucket struct {
+	Index    int64
+	Requests int64
+	Errors   int64
+}
+
+type latencySample struct {
+	Time    time.Time
+	Latency time.Duration
+}
+
+func newEndpointStats(clock clock.Clock) *endpointStats {
+	var es endpointStats
+	es.clock = clock
+	return &es
+}
+
+func (es *endpointStats) BeginRequest() {
+	es.lock.Lock()
+	es.inFlightRequests++
+	es.lock.Unlock()
+}
+
+func (es *endpointStats) EndRequest() {
+	es.lock.Lock()
+	es.inFlightRequests--
+	es.lock.Unlock()
+}
+
+// ObserveRequest records a request whose response (or error) is received.
+func (es *endpointStats) ObserveRequest(latency time.Duration, failed bool) {
+	now := es.clock.Now()
+	es.lock.Lock()
+	defer es.lock.Unlock()
+	bucket := es.getBucket(now)
+	bucket.Requests++
+	if failed {
+		bucket.Errors++
+	}
+	es.latencies[es.latencyCount%maxStatsLatencies] = latencySample{now, latency}
+	es.latencyCount++
+}
+
+func (es *endpointStats) getBucket(now time.Time) *statsBucket {
+	index := now.UnixNano() / int64(statsBucketDuration)
+	bucket := &es.buckets[index%statsBucketCount]
+	if bucket.Index != index {
+		*bucket = statsBucket{Index: index}
+	}
+	return bucket
+}
+
+func (es *endpointStats) Snapshot(ipAddress string) EndpointStats {
+	now := es.clock.Now()
+	index := now.UnixNano() / int64(statsBucketDuration)
+	es.lock.Lock()
+	endpointStats := EndpointStats{
+		IPAddress:        ipAddress,
+		InFlightRequests: es.inFlightRequests,
+	}
+	for i := range es.buckets {
+		bucket := &es.buckets[i]
+		if index-bucket.Index < statsBucketCount {
+			endpointStats.Requests += bucket.Requests
+			endpointStats.Errors += bucket.Errors
+		}
+	}
+	n := es.latencyCount
+	if n > maxStatsLatencies {
+		n = maxStatsLatencies
+	}
+	latencies := make([]time.Duration, 0, n)
+	for _, latencySample := range es.latencies[:n] {
+		if now.Sub(latencySample.Time) < StatsWindow {
+			latencies = append(latencies, latencySample.Latency)
+		}
+	}
+	es.lock.Unlock()
+	if n := len(latencies); n >= 1 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		endpointStats.P50 = latencies[int(0.50*float64(n-1))]
+		endpointStats.P90 = latencies[int(0.90*float64(n-1))]
+		endpointStats.P99 = latencies[int(0.99*float64(n-1))]
+	}
+	return endpointStats
+}
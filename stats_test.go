@@ -0,0 +1,99 @@
+package kubetransport_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	. "github.com/go-tk/kubetransport"
+	"github.com/go-tk/testcase"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateRegistry_Stats(t *testing.T) {
+	type Workspace struct {
+		Init struct {
+			Options   Options
+			MockClock *clock.Mock
+		}
+		ExpOut, ActOut struct {
+			Stats [][]ServiceStats
+		}
+
+		SR *StateRegistry
+	}
+	tc := testcase.New().
+		Step(0, func(t *testing.T, w *Workspace) {
+			w.Init.Options = MakeOptions(nil)
+			w.Init.MockClock = clock.NewMock()
+		}).
+		Step(1, func(t *testing.T, w *Workspace) {
+			w.SR = NewStateRegistry(&w.Init.Options, w.Init.MockClock)
+		}).
+		Step(3, func(t *testing.T, w *Workspace) {
+			assert.Equal(t, w.ExpOut, w.ActOut)
+		})
+	testcase.RunListParallel(t,
+		tc.Copy().
+			Step(2, func(t *testing.T, w *Workspace) {
+				record := func() { w.ActOut.Stats = append(w.ActOut.Stats, w.SR.Stats()) }
+				record()
+				serviceStats := w.SR.GetServiceState("foo", "bar").Stats
+				endpointStats1 := serviceStats.Get("1.2.3.4")
+				for i := 1; i <= 10; i++ {
+					endpointStats1.BeginRequest()
+					endpointStats1.ObserveRequest(time.Duration(i)*time.Millisecond, i > 8)
+					endpointStats1.EndRequest()
+				}
+				endpointStats2 := serviceStats.Get("2.3.4.5")
+				endpointStats2.BeginRequest()
+				record()
+				w.Init.MockClock.Add(StatsWindow)
+				record()
+				w.SR.RemoveEndpointStates("foo", "bar", []string{"1.2.3.4"}, func(string) bool { return false })
+				record()
+				w.ExpOut.Stats = [][]ServiceStats{
+					nil,
+					{
+						{
+							Namespace:     "foo",
+							EndpointsName: "bar",
+							Endpoints: []EndpointStats{
+								{
+									IPAddress: "1.2.3.4",
+									Requests:  10,
+									Errors:    2,
+									P50:       5 * time.Millisecond,
+									P90:       9 * time.Millisecond,
+									P99:       9 * time.Millisecond,
+								},
+								{
+									IPAddress:        "2.3.4.5",
+									InFlightRequests: 1,
+								},
+							},
+						},
+					},
+					{
+						{
+							Namespace:     "foo",
+							EndpointsName: "bar",
+							Endpoints: []EndpointStats{
+								{IPAddress: "1.2.3.4"},
+								{IPAddress: "2.3.4.5", InFlightRequests: 1},
+							},
+						},
+					},
+					{
+						{
+							Namespace:     "foo",
+							EndpointsName: "bar",
+							Endpoints: []EndpointStats{
+								{IPAddress: "2.3.4.5", InFlightRequests: 1},
+							},
+						},
+					},
+				}
+			}),
+	)
+}
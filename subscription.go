@@ -0,0 +1,171 @@
+package kubetransport
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// subscriptionRetryInterval is the initial interval to wait before restarting a failed watch
+// of endpoints which have subscribers. It doubles with each consecutive failure, up to
+// serviceRetryInterval, and is reset once the endpoints are got successfully.
+const subscriptionRetryInterval = 1 * time.Second
+
+// Subscribe subscribes to the changes of the endpoints with the given namespace and name.
+// The callback is called with the current snapshot once it is known and then every time the
+// endpoints change. The calls are made in order from a dedicated goroutine; if the endpoints
+// change faster than the callback returns, the intermediate snapshots are skipped.
+// The endpoints subscribed to are never evicted from the cache.
+// Calling the returned function unsubscribes, after which no more calls will be started.
+func (tw *TransportWrapper) Subscribe(namespace string, endpointsName string, callback func(Snapshot)) (unsubscribe func()) {
+	return tw.endpointsRegistry.Subscribe(namespace, endpointsName, callback)
+}
+
+func (er *endpointsRegistry) Subscribe(namespace string, endpointsName string, callback func(Snapshot)) func() {
+	if namespace == "" {
+		namespace = er.k8sClient.Namespace()
+	}
+	endpointKey := endpointKey{namespace, endpointsName}
+	subscriber := newSubscriber(er.backgroundCtx, callback)
+	er.lock.Lock()
+	er.subscriberSets.Add(endpointKey, subscriber)
+	if value, ok := er.ipAddressesCache.Load(endpointKey); ok {
+		if cachedIPAddresses, ok := value.(*cachedIPAddresses); ok {
+			subscriber.Notify(cachedIPAddresses.Snapshot(endpointKey))
+		}
+	}
+	er.lock.Unlock()
+	er.ensureIPAddresses(endpointKey)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			er.lock.Lock()
+			er.subscriberSets.Remove(endpointKey, subscriber)
+			er.lock.Unlock()
+			subscriber.Stop()
+		})
+	}
+}
+
+func (er *endpointsRegistry) ensureIPAddresses(endpointKey endpointKey) {
+	if _, ok := er.ipAddressesCache.Load(endpointKey); ok {
+		return
+	}
+	results := getIPAddressesResults{Waiter: make(chan struct{})}
+	if _, ok := er.ipAddressesCache.LoadOrStore(endpointKey, &results); !ok {
+		er.doGetIPAddresses(endpointKey, &results)
+	}
+}
+
+func (er *endpointsRegistry) hasSubscribers(endpointKey endpointKey) bool {
+	er.lock.Lock()
+	defer er.lock.Unlock()
+	return len(er.subscriberSets[endpointKey]) >= 1
+}
+
+func (er *endpointsRegistry) publishSnapshot(snapshot Snapshot) {
+	endpointKey := endpointKey{snapshot.Namespace, snapshot.EndpointsName}
+	er.lock.Lock()
+	for subscriber := range er.subscriberSets[endpointKey] {
+		subscriber.Notify(snapshot)
+	}
+	er.lock.Unlock()
+}
+
+func (er *endpointsRegistry) resubscribe(endpointKey endpointKey) {
+	failureCount := 0
+	if value, ok := er.watchFailures.Load(endpointKey); ok {
+		failureCount = value.(*watchFailure).Count
+	}
+	time.AfterFunc(subscriptionRetryDelay(failureCount), func() {
+		if er.backgroundCtx.Err() != nil || !er.hasSubscribers(endpointKey) {
+			return
+		}
+		er.ensureIPAddresses(endpointKey)
+	})
+}
+
+// subscriptionRetryDelay returns the interval to wait before restarting a watch after the
+// given number of consecutive failures.
+func subscriptionRetryDelay(failureCount int) time.Duration {
+	delay := subscriptionRetryInterval
+	for i := 1; i < failureCount && delay < serviceRetryInterval; i++ {
+		delay *= 2
+	}
+	if delay > serviceRetryInterval {
+		delay = serviceRetryInterval
+	}
+	return delay
+}
+
+type subscriberSets map[endpointKey]map[*subscriber]struct{}
+
+func (ss *subscriberSets) Add(endpointKey endpointKey, s *subscriber) {
+	if *ss == nil {
+		*ss = make(subscriberSets)
+	}
+	subscriberSet, ok := (*ss)[endpointKey]
+	if !ok {
+		subscriberSet = make(map[*subscriber]struct{})
+		(*ss)[endpointKey] = subscriberSet
+	}
+	subscriberSet[s] = struct{}{}
+}
+
+func (ss subscriberSets) Remove(endpointKey endpointKey, s *subscriber) {
+	subscriberSet := ss[endpointKey]
+	delete(subscriberSet, s)
+	if len(subscriberSet) == 0 {
+		delete(ss, endpointKey)
+	}
+}
+
+type subscriber struct {
+	backgroundCtx context.Context
+	stop          context.CancelFunc
+	callback      func(Snapshot)
+	wakeup        chan struct{}
+
+	lock     sync.Mutex
+	snapshot *Snapshot
+}
+
+func newSubscriber(backgroundCtx context.Context, callback func(Snapshot)) *subscriber {
+	var s subscriber
+	s.backgroundCtx, s.stop = context.WithCancel(backgroundCtx)
+	s.callback = callback
+	s.wakeup = make(chan struct{}, 1)
+	go s.run()
+	return &s
+}
+
+func (s *subscriber) run() {
+	for {
+		select {
+		case <-s.backgroundCtx.Done():
+			return
+		case <-s.wakeup:
+		}
+		s.lock.Lock()
+		snapshot := s.snapshot
+		s.snapshot = nil
+		s.lock.Unlock()
+		if snapshot != nil && s.backgroundCtx.Err() == nil {
+			// The snapshot is shared among the subscribers.
+			snapshot.IPAddresses = copyIPAddresses(snapshot.IPAddresses)
+			s.callback(*snapshot)
+		}
+	}
+}
+
+func (s *subscriber) Notify(snapshot Snapshot) {
+	s.lock.Lock()
+	s.snapshot = &snapshot
+	s.lock.Unlock()
+	select {
+	case s.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscriber) Stop() { s.stop() }
@@ -0,0 +1,218 @@
+package kubetransport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-tk/kubetransport/internal/k8sclient"
+)
+
+// SubsetHeader is the request header to select a subset of the endpoints (pods) by their
+// labels, e.g. "version=v2" or "version=v2,track=canary". If no endpoint matches, the request
+// is sent to any of the endpoints. The header is not forwarded. Selecting subsets requires
+// the permission to get services and to list and watch pods.
+const SubsetHeader = "X-Kube-Subset"
+
+type subsetKey struct{}
+
+// WithSubset returns a copy of the given context which selects a subset of the endpoints
+// (pods) by their labels for the requests sent with it, like SubsetHeader, which it takes
+// precedence over.
+func WithSubset(ctx context.Context, labels map[string]string) context.Context {
+	return context.WithValue(ctx, subsetKey{}, labels)
+}
+
+// getSubsetSelector returns the labels of the subset selected for the request, or nil if
+// no subset is selected.
+func getSubsetSelector(request *http.Request) (map[string]string, error) {
+	if labels, ok := request.Context().Value(subsetKey{}).(map[string]string); ok {
+		return labels, nil
+	}
+	value := request.Header.Get(SubsetHeader)
+	if value == "" {
+		return nil, nil
+	}
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		i := strings.IndexByte(pair, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("kubetransport: invalid subset header; value=%q", value)
+		}
+		labels[strings.TrimSpace(pair[:i])] = strings.TrimSpace(pair[i+1:])
+	}
+	return labels, nil
+}
+
+type podRef struct {
+	Namespace string
+	Name      string
+}
+
+// FilterIPAddressesByPodLabels returns the ip addresses of the endpoints of the given
+// endpoints whose pods have all the given labels. The pods of the endpoints are listed the
+// first time and watched in the background, see podLabels.
+func (er *endpointsRegistry) FilterIPAddressesByPodLabels(ctx context.Context, namespace string, endpointsName string, ipAddresses []string, labels map[string]string) ([]string, error) {
+	cachedIPAddresses := er.getCachedIPAddresses(namespace, endpointsName)
+	if cachedIPAddresses == nil {
+		return nil, nil
+	}
+	podLabels := cachedIPAddresses.Source.PodLabels()
+	select {
+	case <-podLabels.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	var filteredIPAddresses []string
+	for _, ipAddress := range ipAddresses {
+		podRef, ok := cachedIPAddresses.PodRefs[ipAddress]
+		if !ok {
+			continue
+		}
+		if matchLabels(podLabels.Get(podRef.Name), labels) {
+			filteredIPAddresses = append(filteredIPAddresses, ipAddress)
+		}
+	}
+	return filteredIPAddresses, nil
+}
+
+// podLabels holds the labels of the pods of a service by pod name, which are listed and
+// watched with the selector of the Service, in one go for all the pods.
+type podLabels struct {
+	ready chan struct{}
+
+	lock   sync.Mutex
+	labels map[string]map[string]string
+}
+
+// Get returns the labels of the pod with the given name.
+func (pl *podLabels) Get(podName string) map[string]string {
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+	return pl.labels[podName]
+}
+
+func (pl *podLabels) set(podName string, labels map[string]string) {
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+	if labels == nil {
+		delete(pl.labels, podName)
+		return
+	}
+	pl.labels[podName] = labels
+}
+
+func (pl *podLabels) reset(labels map[string]map[string]string) {
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+	pl.labels = labels
+}
+
+// PodLabels returns the labels of the pods of the endpoints, starting to list and watch the
+// pods the first time.
+func (ipas *ipAddressesSource) PodLabels() *podLabels {
+	ipas.podLabelsOnce.Do(func() {
+		ipas.podLabels = &podLabels{ready: make(chan struct{})}
+		go ipas.listAndWatchPods(ipas.podLabels)
+	})
+	return ipas.podLabels
+}
+
+// podRetryInterval is the interval to wait before listing the pods again after a failure.
+const podRetryInterval = 1 * time.Minute
+
+func (ipas *ipAddressesSource) listAndWatchPods(podLabels *podLabels) {
+	var ready bool
+	setReady := func() {
+		if !ready {
+			ready = true
+			close(podLabels.ready)
+		}
+	}
+	for {
+		t0 := time.Now()
+		err := ipas.doListAndWatchPods(podLabels, setReady)
+		// Requests go on without the labels if the pods fail to list.
+		setReady()
+		if ipas.IsStopped() {
+			return
+		}
+		if errors.Is(err, k8sclient.ErrResourceVersionExpired) && time.Since(t0) >= minWatchDuration {
+			ipas.options.Logger.Debug("resource version expired, relisting pods", ipas.keyLogArgs("error", err)...)
+			continue
+		}
+		ipas.options.Logger.Warn("pod watch failed", ipas.keyLogArgs("error", err)...)
+		select {
+		case <-ipas.backgroundCtx.Done():
+			return
+		case <-time.After(podRetryInterval):
+		}
+	}
+}
+
+func (ipas *ipAddressesSource) doListAndWatchPods(podLabels *podLabels, setReady func()) error {
+	// The selector of the Service is got every time in case it changes.
+	service, err := ipas.k8sClient.GetService(ipas.backgroundCtx, ipas.namespace, ipas.endpointsName)
+	if err != nil {
+		return fmt.Errorf("get service: %w", err)
+	}
+	if service == nil || len(service.Spec.Selector) == 0 {
+		// The endpoints are managed manually.
+		podLabels.reset(nil)
+		setReady()
+		<-ipas.backgroundCtx.Done()
+		return ipas.backgroundCtx.Err()
+	}
+	labelSelector := formatLabelSelector(service.Spec.Selector)
+	podList, err := ipas.k8sClient.ListPods(ipas.backgroundCtx, ipas.namespace, labelSelector)
+	if err != nil {
+		return fmt.Errorf("list pods; labelSelector=%q: %w", labelSelector, err)
+	}
+	labels := make(map[string]map[string]string, len(podList.Items))
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		labels[pod.Metadata.Name] = pod.Metadata.Labels
+	}
+	podLabels.reset(labels)
+	setReady()
+	ipas.options.Logger.Debug("listed pods", ipas.keyLogArgs("labelSelector", labelSelector, "podCount", len(labels))...)
+	resourceVersion := podList.Metadata.ResourceVersion
+	return ipas.watch(func() error {
+		return ipas.k8sClient.WatchPods(ipas.backgroundCtx, ipas.namespace, labelSelector, resourceVersion, func(eventType k8sclient.EventType, pod *k8sclient.Pod) bool {
+			if pod == nil {
+				return true
+			}
+			resourceVersion = pod.Metadata.ResourceVersion
+			if eventType == k8sclient.EventDeleted {
+				podLabels.set(pod.Metadata.Name, nil)
+			} else {
+				podLabels.set(pod.Metadata.Name, pod.Metadata.Labels)
+			}
+			return true
+		})
+	})
+}
+
+// formatLabelSelector formats the given labels into a label selector, e.g. "app=foo,tier=web".
+func formatLabelSelector(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func matchLabels(labels map[string]string, selector map[string]string) bool {
+	for key, value := range selector {
+		if value2, ok := labels[key]; !ok || value2 != value {
+			return false
+		}
+	}
+	return true
+}
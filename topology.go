@@ -0,0 +1,257 @@
+package kubetransport
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ZoneAwareRouting configures zone-aware routing, which keeps the requests in the zone of
+// the client while the zone has enough healthy endpoints (pods), and otherwise spills them
+// over to other zones in proportion to the unhealthy endpoints in the local zone.
+// With WithEndpointSlices, the zones of the endpoints are learned from EndpointSlices, along
+// with the topology-aware hints, which take precedence if all the endpoints have them.
+// Otherwise they are learned from the topology.kubernetes.io/zone label of the nodes of the
+// endpoints, which requires the permission to get nodes.
+type ZoneAwareRouting struct {
+	// Zone is the zone of the client. Empty means learning it from the KUBETRANSPORT_ZONE
+	// environment variable, or else the topology.kubernetes.io/zone label of the node named
+	// by the NODE_NAME environment variable.
+	Zone string
+
+	// MinHealthyPercentage is the minimum percentage of the healthy endpoints in the local
+	// zone to keep all the requests in the local zone. Zero means 70.
+	MinHealthyPercentage float64
+}
+
+// WithZoneAwareRouting enables zone-aware routing.
+func WithZoneAwareRouting(zoneAwareRouting ZoneAwareRouting) Option {
+	return func(options *options) {
+		if zoneAwareRouting.MinHealthyPercentage <= 0 {
+			zoneAwareRouting.MinHealthyPercentage = defaultMinHealthyPercentage
+		}
+		options.ZoneAwareRouting = &zoneAwareRouting
+		options.LocalZone = zoneAwareRouting.Zone
+	}
+}
+
+const defaultMinHealthyPercentage = 70
+
+const (
+	zoneLabel          = "topology.kubernetes.io/zone"
+	zoneEnvVarName     = "KUBETRANSPORT_ZONE"
+	nodeNameEnvVarName = "NODE_NAME"
+)
+
+// nodeZoneRetryInterval is the interval to wait before getting a node again after a failure.
+const nodeZoneRetryInterval = 1 * time.Minute
+
+type nodeZone struct {
+	lock       sync.Mutex
+	zone       string
+	known      bool
+	fetching   bool
+	retryAfter time.Time
+}
+
+// LocalZone returns the zone of the client, or an empty string if it is unknown (yet).
+func (er *endpointsRegistry) LocalZone() string {
+	if er.options.LocalZone != "" {
+		return er.options.LocalZone
+	}
+	if er.options.NodeName == "" {
+		return ""
+	}
+	zone, _ := er.GetNodeZone(er.options.NodeName)
+	return zone
+}
+
+// endpointTopology is the topology of an endpoint (pod) told by EndpointSlices.
+type endpointTopology struct {
+	Zone string
+
+	// HintZones are the zones whose clients should consume the endpoint, as hinted by the
+	// topology-aware routing of Kubernetes.
+	HintZones []string
+}
+
+// GetZone returns the zone of the endpoint with the given ip address of the given endpoints,
+// or an empty string if it is unknown (yet).
+func (er *endpointsRegistry) GetZone(namespace string, endpointsName string, ipAddress string) string {
+	cachedIPAddresses := er.getCachedIPAddresses(namespace, endpointsName)
+	if cachedIPAddresses == nil {
+		return ""
+	}
+	return er.getZone(cachedIPAddresses, ipAddress)
+}
+
+func (er *endpointsRegistry) getZone(cachedIPAddresses *cachedIPAddresses, ipAddress string) string {
+	if zone := cachedIPAddresses.EndpointTopologies[ipAddress].Zone; zone != "" {
+		return zone
+	}
+	nodeName := cachedIPAddresses.NodeNames[ipAddress]
+	if nodeName == "" {
+		return ""
+	}
+	zone, _ := er.GetNodeZone(nodeName)
+	return zone
+}
+
+// GetNodeZone returns the zone of the node with the given name. If the zone is not known
+// yet, the node is got in the background and false is returned.
+func (er *endpointsRegistry) GetNodeZone(nodeName string) (string, bool) {
+	value, ok := er.nodeZones.Load(nodeName)
+	if !ok {
+		value, _ = er.nodeZones.LoadOrStore(nodeName, new(nodeZone))
+	}
+	nodeZone := value.(*nodeZone)
+	nodeZone.lock.Lock()
+	defer nodeZone.lock.Unlock()
+	if nodeZone.known {
+		return nodeZone.zone, true
+	}
+	if !nodeZone.fetching && !time.Now().Before(nodeZone.retryAfter) {
+		nodeZone.fetching = true
+		go er.fetchNodeZone(nodeName, nodeZone)
+	}
+	return "", false
+}
+
+func (er *endpointsRegistry) fetchNodeZone(nodeName string, nodeZone *nodeZone) {
+	node, err := er.k8sClient.GetNode(er.backgroundCtx, nodeName)
+	nodeZone.lock.Lock()
+	defer nodeZone.lock.Unlock()
+	nodeZone.fetching = false
+	if err != nil {
+		if er.backgroundCtx.Err() == nil {
+			er.options.Logger.Warn("failed to get node", "nodeName", nodeName, "error", err)
+		}
+		nodeZone.retryAfter = time.Now().Add(nodeZoneRetryInterval)
+		return
+	}
+	if node != nil {
+		nodeZone.zone = node.Metadata.Labels[zoneLabel]
+	}
+	nodeZone.known = true
+}
+
+func containsZone(zones []string, zone string) bool {
+	for _, otherZone := range zones {
+		if otherZone == zone {
+			return true
+		}
+	}
+	return false
+}
+
+// filterIPAddressesByZone returns the ip addresses of the target to pick from with
+// zone-aware routing.
+func (kt *kubeTransport) filterIPAddressesByZone(target *requestTarget) []string {
+	zoneAwareRouting := kt.options.ZoneAwareRouting
+	if zoneAwareRouting == nil {
+		return target.IPAddresses
+	}
+	localZone := kt.endpointsRegistry.LocalZone()
+	if localZone == "" {
+		return target.IPAddresses
+	}
+	cachedIPAddresses := kt.endpointsRegistry.getCachedIPAddresses(target.Namespace, target.EndpointsName)
+	if cachedIPAddresses == nil {
+		return target.IPAddresses
+	}
+	// Like kube-proxy, the hints are only used if all the endpoints have hints.
+	useHints := true
+	for _, ipAddress := range target.IPAddresses {
+		if len(cachedIPAddresses.EndpointTopologies[ipAddress].HintZones) == 0 {
+			useHints = false
+			break
+		}
+	}
+	var localIPAddresses, remoteIPAddresses []string
+	var localHealthyCount int
+	for _, ipAddress := range target.IPAddresses {
+		var isLocal bool
+		if useHints {
+			isLocal = containsZone(cachedIPAddresses.EndpointTopologies[ipAddress].HintZones, localZone)
+		} else {
+			isLocal = kt.endpointsRegistry.getZone(cachedIPAddresses, ipAddress) == localZone
+		}
+		if !isLocal {
+			remoteIPAddresses = append(remoteIPAddresses, ipAddress)
+			continue
+		}
+		localIPAddresses = append(localIPAddresses, ipAddress)
+		if kt.stateRegistry.GetEndpointState(ipAddress).IsHealthy() {
+			localHealthyCount++
+		}
+	}
+	if len(localIPAddresses) == 0 || len(remoteIPAddresses) == 0 {
+		return target.IPAddresses
+	}
+	healthyRatio := float64(localHealthyCount) / float64(len(localIPAddresses))
+	if healthyRatio*100 >= zoneAwareRouting.MinHealthyPercentage {
+		return localIPAddresses
+	}
+	if float64(splitmix64(&kt.seed)>>11)/(1<<53) < healthyRatio {
+		return localIPAddresses
+	}
+	return remoteIPAddresses
+}
+
+// NodeLocalPolicy is the policy to keep the requests on the node of the client, like
+// internalTrafficPolicy: Local of Services. The node of the client is learned from the
+// NODE_NAME environment variable, which can be set with the downward API.
+type NodeLocalPolicy int
+
+const (
+	// NodeLocalPolicyNone does not prefer the endpoints on the node of the client.
+	NodeLocalPolicyNone NodeLocalPolicy = iota
+
+	// NodeLocalPolicyPreferLocal sends the requests to the endpoints (pods) on the node of
+	// the client if any is available, or else to the other endpoints.
+	NodeLocalPolicyPreferLocal
+
+	// NodeLocalPolicyLocalOnly sends the requests only to the endpoints (pods) on the node
+	// of the client. Requests fail with ErrNoIPAddress if there is no such endpoint.
+	NodeLocalPolicyLocalOnly
+)
+
+// WithNodeLocalPolicy sets the node-local policy for all the requests. Defaults to
+// NodeLocalPolicyNone.
+func WithNodeLocalPolicy(nodeLocalPolicy NodeLocalPolicy) Option {
+	return func(options *options) { options.NodeLocalPolicy = nodeLocalPolicy }
+}
+
+type nodeLocalPolicyKey struct{}
+
+// WithRequestNodeLocalPolicy returns a copy of the given context which overrides the
+// node-local policy for the requests sent with it.
+func WithRequestNodeLocalPolicy(ctx context.Context, nodeLocalPolicy NodeLocalPolicy) context.Context {
+	return context.WithValue(ctx, nodeLocalPolicyKey{}, nodeLocalPolicy)
+}
+
+func (kt *kubeTransport) nodeLocalPolicy(ctx context.Context) NodeLocalPolicy {
+	if nodeLocalPolicy, ok := ctx.Value(nodeLocalPolicyKey{}).(NodeLocalPolicy); ok {
+		return nodeLocalPolicy
+	}
+	return kt.options.NodeLocalPolicy
+}
+
+// filterIPAddressesByNode returns the ip addresses of the target on the node of the client.
+func (kt *kubeTransport) filterIPAddressesByNode(target *requestTarget) []string {
+	nodeName := kt.options.NodeName
+	if nodeName == "" {
+		return nil
+	}
+	cachedIPAddresses := kt.endpointsRegistry.getCachedIPAddresses(target.Namespace, target.EndpointsName)
+	if cachedIPAddresses == nil {
+		return nil
+	}
+	var localIPAddresses []string
+	for _, ipAddress := range target.IPAddresses {
+		if cachedIPAddresses.NodeNames[ipAddress] == nodeName {
+			localIPAddresses = append(localIPAddresses, ipAddress)
+		}
+	}
+	return localIPAddresses
+}
@@ -0,0 +1,97 @@
+package kubetransport
+
+import (
+	"context"
+	"errors"
+)
+
+// Tracer starts spans around the stages of the requests sent with the kube- schemes, so that
+// they can be reported to a distributed tracing system, e.g. by an OpenTelemetry adapter.
+// Implementations must be safe for concurrent use.
+type Tracer interface {
+	// StartSpan starts a span with the given name and attributes as a child of the span in
+	// the given context, if any, and returns a copy of the context carrying the new span.
+	StartSpan(ctx context.Context, name string, attributes []Attribute) (context.Context, Span)
+}
+
+// Span is a span started by Tracer.
+type Span interface {
+	// End ends the span with the given additional attributes and the error occurred, if any.
+	End(attributes []Attribute, err error)
+}
+
+// Attribute is a key-value pair attached to a span. The value is a string, an int or a bool.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// The names of the spans started.
+const (
+	// SpanResolve spans the resolution of the ip addresses of endpoints.
+	// Start attributes: namespace, endpoints_name.
+	// End attributes: address_count, cache_hit, external_name (for ExternalName Services),
+	// failover_namespace, failover_endpoints_name (for requests failed over to a standby
+	// service), error_type (on error).
+	SpanResolve = "kubetransport.resolve"
+
+	// SpanPick spans picking an endpoint (pod) for a request, once per attempt.
+	// Start attributes: namespace, endpoints_name, address_count.
+	// End attributes: ip_address, error_type (on error).
+	SpanPick = "kubetransport.pick"
+)
+
+// The keys of the attributes attached to the spans.
+const (
+	AttributeNamespace     = "kubetransport.namespace"
+	AttributeEndpointsName = "kubetransport.endpoints_name"
+	AttributeAddressCount  = "kubetransport.address_count"
+	AttributeCacheHit      = "kubetransport.cache_hit"
+	AttributeIPAddress     = "kubetransport.ip_address"
+	AttributeErrorType     = "kubetransport.error_type"
+	AttributeExternalName  = "kubetransport.external_name"
+
+	AttributeFailoverNamespace     = "kubetransport.failover_namespace"
+	AttributeFailoverEndpointsName = "kubetransport.failover_endpoints_name"
+)
+
+func endpointsAttributes(namespace string, endpointsName string, attributes ...Attribute) []Attribute {
+	return append([]Attribute{{AttributeNamespace, namespace}, {AttributeEndpointsName, endpointsName}}, attributes...)
+}
+
+// errorType classifies the given error for AttributeErrorType.
+func errorType(err error) string {
+	switch {
+	case errors.Is(err, ErrEndpointsNotFound):
+		return "endpoints_not_found"
+	case errors.Is(err, ErrNoIPAddress):
+		return "no_ip_address"
+	case errors.Is(err, ErrOverloaded):
+		return "overloaded"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "deadline_exceeded"
+	default:
+		return "other"
+	}
+}
+
+func endSpan(span Span, attributes []Attribute, err error) {
+	if err != nil {
+		attributes = append(attributes, Attribute{AttributeErrorType, errorType(err)})
+	}
+	span.End(attributes, err)
+}
+
+type dummyTracer struct{}
+
+var _ Tracer = dummyTracer{}
+
+func (dummyTracer) StartSpan(ctx context.Context, _ string, _ []Attribute) (context.Context, Span) {
+	return ctx, dummySpan{}
+}
+
+type dummySpan struct{}
+
+func (dummySpan) End([]Attribute, error) {}
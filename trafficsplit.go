@@ -0,0 +1,100 @@
+package kubetransport
+
+// TrafficSplit splits the traffic to a logical service across backing services by weight,
+// e.g. for blue/green and canary releases.
+type TrafficSplit struct {
+	Backends []TrafficSplitBackend
+}
+
+// TrafficSplitBackend is a backing service of a traffic split.
+type TrafficSplitBackend struct {
+	// Namespace is the namespace of the backing service. Empty means the namespace of the
+	// logical service.
+	Namespace string
+
+	EndpointsName string
+
+	// Weight is the relative weight of the backing service. Backing services with
+	// non-positive weights receive no traffic.
+	Weight int
+}
+
+// WithTrafficSplit sets the traffic split for the logical service with the given namespace
+// and name. Empty namespace means the namespace of the client. It can be adjusted later with
+// TransportWrapper.SetTrafficSplit.
+func WithTrafficSplit(namespace string, endpointsName string, trafficSplit TrafficSplit) Option {
+	return func(options *options) {
+		if options.TrafficSplits == nil {
+			options.TrafficSplits = make(map[endpointKey]TrafficSplit)
+		}
+		options.TrafficSplits[endpointKey{namespace, endpointsName}] = trafficSplit
+	}
+}
+
+// SetTrafficSplit sets or replaces the traffic split for the logical service with the given
+// namespace and name, which takes effect for the subsequent requests. Empty namespace means
+// the namespace of the client.
+func (tw *TransportWrapper) SetTrafficSplit(namespace string, endpointsName string, trafficSplit TrafficSplit) {
+	tw.endpointsRegistry.SetTrafficSplit(namespace, endpointsName, trafficSplit)
+}
+
+// RemoveTrafficSplit removes the traffic split for the logical service with the given
+// namespace and name. Empty namespace means the namespace of the client.
+func (tw *TransportWrapper) RemoveTrafficSplit(namespace string, endpointsName string) {
+	tw.endpointsRegistry.RemoveTrafficSplit(namespace, endpointsName)
+}
+
+type weightedTrafficSplit struct {
+	Backends    []TrafficSplitBackend
+	TotalWeight int
+}
+
+func (er *endpointsRegistry) SetTrafficSplit(namespace string, endpointsName string, trafficSplit TrafficSplit) {
+	if namespace == "" {
+		namespace = er.k8sClient.Namespace()
+	}
+	er.trafficSplits.Store(endpointKey{namespace, endpointsName}, newWeightedTrafficSplit(namespace, trafficSplit))
+}
+
+func (er *endpointsRegistry) RemoveTrafficSplit(namespace string, endpointsName string) {
+	if namespace == "" {
+		namespace = er.k8sClient.Namespace()
+	}
+	er.trafficSplits.Delete(endpointKey{namespace, endpointsName})
+}
+
+func newWeightedTrafficSplit(namespace string, trafficSplit TrafficSplit) *weightedTrafficSplit {
+	var wts weightedTrafficSplit
+	for _, backend := range trafficSplit.Backends {
+		if backend.Weight <= 0 {
+			continue
+		}
+		if backend.Namespace == "" {
+			backend.Namespace = namespace
+		}
+		wts.Backends = append(wts.Backends, backend)
+		wts.TotalWeight += backend.Weight
+	}
+	return &wts
+}
+
+// splitTraffic returns the namespace and the name of the backing service to send a request
+// for the given logical service to, which are the given ones if there is no traffic split.
+func (kt *kubeTransport) splitTraffic(namespace string, endpointsName string) (string, string) {
+	value, ok := kt.endpointsRegistry.trafficSplits.Load(endpointKey{namespace, endpointsName})
+	if !ok {
+		return namespace, endpointsName
+	}
+	trafficSplit := value.(*weightedTrafficSplit)
+	if trafficSplit.TotalWeight == 0 {
+		return namespace, endpointsName
+	}
+	x := int(splitmix64(&kt.seed) % uint64(trafficSplit.TotalWeight))
+	for _, backend := range trafficSplit.Backends {
+		if x < backend.Weight {
+			return backend.Namespace, backend.EndpointsName
+		}
+		x -= backend.Weight
+	}
+	panic("unreachable code")
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"os"
 	"sync"
 	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/go-tk/kubetransport/internal/k8sclient"
 )
 
@@ -20,10 +22,11 @@ func MustWrapTransport(transport http.RoundTripper) http.RoundTripper {
 }
 
 // WrapTransport wraps the given transport for client-side load balancing in Kubernetes.
+// The transports wrapped share a transport wrapper with the default options.
 func WrapTransport(transport http.RoundTripper) (http.RoundTripper, error) {
 	newTransportWrapperOnce.Do(func() {
-		r1, r2 := newTransportWrapper()
-		newTransportWrapperResults = func() (*transportWrapper, error) { return r1, r2 }
+		r1, r2 := NewTransportWrapper()
+		newTransportWrapperResults = func() (*TransportWrapper, error) { return r1, r2 }
 	})
 	transportWrapper, err := newTransportWrapperResults()
 	if err != nil {
@@ -34,23 +37,45 @@ func WrapTransport(transport http.RoundTripper) (http.RoundTripper, error) {
 
 var (
 	newTransportWrapperOnce    sync.Once
-	newTransportWrapperResults func() (*transportWrapper, error)
+	newTransportWrapperResults func() (*TransportWrapper, error)
 )
 
-type transportWrapper struct {
+// TransportWrapper wraps transports for client-side load balancing in Kubernetes.
+// The transports wrapped by the same transport wrapper share the endpoints cache,
+// the limits and the circuit breakers.
+type TransportWrapper struct {
+	options           options
 	endpointsRegistry *endpointsRegistry
+	stateRegistry     *stateRegistry
 }
 
-func newTransportWrapper() (*transportWrapper, error) {
-	var tw transportWrapper
-	k8sClient, err := k8sclient.New()
+// NewTransportWrapper creates a transport wrapper with the given options.
+func NewTransportWrapper(options ...Option) (*TransportWrapper, error) {
+	var tw TransportWrapper
+	tw.options = makeOptions(options)
+	if tw.options.ZoneAwareRouting != nil && tw.options.LocalZone == "" {
+		tw.options.LocalZone = os.Getenv(zoneEnvVarName)
+	}
+	tw.options.NodeName = os.Getenv(nodeNameEnvVarName)
+	k8sClient, err := k8sclient.New(tw.options.Logger)
 	if err != nil {
 		return nil, err
 	}
-	tw.endpointsRegistry = newEndpointsRegistry(context.Background(), k8sClient, 1*time.Minute)
+	tw.endpointsRegistry = newEndpointsRegistry(context.Background(), k8sClient, &tw.options, 1*time.Minute)
+	tw.stateRegistry = newStateRegistry(&tw.options, clock.New())
+	tw.endpointsRegistry.AddRemovalCallback(func(endpointKey endpointKey, ipAddresses []string) {
+		tw.stateRegistry.RemoveEndpointStates(endpointKey.Namespace, endpointKey.EndpointsName, ipAddresses, tw.endpointsRegistry.IsIPAddressInUse)
+	})
+	tw.endpointsRegistry.AddRemovalCallback(func(endpointKey endpointKey, ipAddresses []string) {
+		deleteEndpointMetrics(tw.options.MetricsCollector, endpointKey.Namespace, endpointKey.EndpointsName, ipAddresses)
+	})
 	return &tw, nil
 }
 
-func (tw *transportWrapper) WrapTransport(transport http.RoundTripper) http.RoundTripper {
-	return newKubeTransport(tw.endpointsRegistry, transport, uint64(time.Now().UnixNano()))
+// WrapTransport wraps the given transport for client-side load balancing in Kubernetes.
+func (tw *TransportWrapper) WrapTransport(transport http.RoundTripper) http.RoundTripper {
+	return newKubeTransport(tw.endpointsRegistry, tw.stateRegistry, &tw.options, transport, uint64(time.Now().UnixNano()))
 }
+
+// Stop stops watching endpoints in the background.
+func (tw *TransportWrapper) Stop() { tw.endpointsRegistry.Stop() }